@@ -0,0 +1,362 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"go/format"
+	"sort"
+	"strings"
+	"unicode"
+
+	"github.com/gebv/ffsm"
+)
+
+type options struct {
+	pkg    string
+	typ    string // name of the type of FSM
+	source string // name of the file of the definition
+}
+
+// generate returns Go code of the definition.
+func generate(d *ffsm.Definition, opts options) ([]byte, error) {
+	if d.Initial == ffsm.UnknownState {
+		return nil, fmt.Errorf("initial state is not defined")
+	}
+	if _, err := d.Build(stubRegistry(d)); err != nil {
+		return nil, err
+	}
+
+	states := definitionStates(d)
+	var events []string
+	var targets []string
+	seen := map[string]bool{}
+	for _, t := range d.Transitions {
+		if t.Event != "" && !seen["event "+t.Event] {
+			seen["event "+t.Event] = true
+			events = append(events, t.Event)
+		}
+		if t.Event == "" && t.To != ffsm.AnyState && !seen["state "+t.To] {
+			seen["state "+t.To] = true
+			targets = append(targets, t.To)
+		}
+	}
+	sort.Strings(events)
+	sort.Strings(targets)
+
+	names := map[string]string{} // identifier => source of it
+	declare := func(ident, source string) error {
+		if prev, ok := names[ident]; ok {
+			return fmt.Errorf("%s and %s have the same identifier %s", prev, source, ident)
+		}
+		names[ident] = source
+		return nil
+	}
+	if err := declare(opts.typ+"Initial", "initial state"); err != nil {
+		return nil, err
+	}
+	if err := declare(opts.typ+"State", "type of states"); err != nil {
+		return nil, err
+	}
+	if len(events) > 0 {
+		if err := declare(opts.typ+"Event", "type of events"); err != nil {
+			return nil, err
+		}
+	}
+	for _, s := range states {
+		if err := declare(opts.typ+identifier(s), fmt.Sprintf("state %q", s)); err != nil {
+			return nil, err
+		}
+	}
+	for _, e := range events {
+		if err := declare(opts.typ+"Event"+identifier(e), fmt.Sprintf("event %q", e)); err != nil {
+			return nil, err
+		}
+	}
+	methods := map[string]string{}
+	for _, m := range []string{"State", "Dispatch", "DispatchEvent", "Untyped", "Stop"} {
+		methods[m] = "method"
+	}
+	method := func(ident, source string) error {
+		if prev, ok := methods[ident]; ok {
+			return fmt.Errorf("%s and %s have the same method %s", prev, source, ident)
+		}
+		methods[ident] = source
+		return nil
+	}
+	for _, e := range events {
+		if err := method(identifier(e), fmt.Sprintf("event %q", e)); err != nil {
+			return nil, err
+		}
+	}
+	for _, s := range targets {
+		if err := method("To"+identifier(s), fmt.Sprintf("state %q", s)); err != nil {
+			return nil, err
+		}
+	}
+
+	var b bytes.Buffer
+	w := func(format string, args ...interface{}) {
+		fmt.Fprintf(&b, format, args...)
+		b.WriteString("\n")
+	}
+	t := opts.typ
+	unexported := string(unicode.ToLower(rune(t[0]))) + t[1:]
+
+	w("// Code generated by ffsmgen from %s. DO NOT EDIT.", opts.source)
+	w("")
+	w("package %s", opts.pkg)
+	w("")
+	w("import (")
+	w("%q", "context")
+	w("")
+	w("%q", "github.com/gebv/ffsm")
+	w(")")
+	w("")
+	w("// %sState is the state of %s.", t, t)
+	w("type %sState string", t)
+	w("")
+	w("// States of %s.", t)
+	w("const (")
+	for _, s := range states {
+		w("%s%s %sState = %q", t, identifier(s), t, s)
+	}
+	w(")")
+	w("")
+	w("// %sInitial is the initial state of %s.", t, t)
+	w("const %sInitial = %s%s", t, t, identifier(d.Initial))
+	if len(events) > 0 {
+		w("")
+		w("// %sEvent is the event of %s.", t, t)
+		w("type %sEvent string", t)
+		w("")
+		w("// Events of %s.", t)
+		w("const (")
+		for _, e := range events {
+			w("%sEvent%s %sEvent = %q", t, identifier(e), t, e)
+		}
+		w(")")
+	}
+	w("")
+	w("var %sDefinition = %s", unexported, definitionLiteral(d))
+	w("")
+	w("// New%sStack returns Stack of %s with procedures and guards of the registry.", t, t)
+	w("func New%sStack(reg *ffsm.Registry) (ffsm.Stack, error) {", t)
+	w("return %sDefinition.Build(reg)", unexported)
+	w("}")
+	w("")
+	w("// %s is FSM with methods of transitions of %s. Only states and events of", t, t)
+	w("// %s are dispatched.", t)
+	w("type %s struct {", t)
+	w("fsm *ffsm.FSM")
+	w("}")
+	w("")
+	w("// New%s returns %s in the initial state with procedures and guards of the registry.", t, t)
+	w("func New%s(reg *ffsm.Registry) (*%s, error) {", t, t)
+	w("wf, err := New%sStack(reg)", t)
+	w("if err != nil {")
+	w("return nil, err")
+	w("}")
+	w("return &%s{fsm: ffsm.NewFSM(wf, string(%sInitial))}, nil", t, t)
+	w("}")
+	w("")
+	w("// Wrap%s returns %s of FSM with Stack of New%sStack (for example created", t, t, t)
+	w("// by the engine or with the store).")
+	w("func Wrap%s(fsm *ffsm.FSM) *%s {", t, t)
+	w("return &%s{fsm: fsm}", t)
+	w("}")
+	w("")
+	w("// State returns current state.")
+	w("func (m *%s) State() %sState {", t, t)
+	w("return %sState(m.fsm.State())", t)
+	w("}")
+	w("")
+	w("// Dispatch dispatches the transition to the state.")
+	w("func (m *%s) Dispatch(ctx context.Context, state %sState) error {", t, t)
+	w("return m.fsm.Dispatch(ctx, string(state))")
+	w("}")
+	if len(events) > 0 {
+		w("")
+		w("// DispatchEvent dispatches the event.")
+		w("func (m *%s) DispatchEvent(ctx context.Context, event %sEvent) error {", t, t)
+		w("return m.fsm.DispatchEvent(ctx, string(event))")
+		w("}")
+	}
+	w("")
+	w("// Untyped returns FSM of %s, for example to add interceptors.", t)
+	w("func (m *%s) Untyped() *ffsm.FSM {", t)
+	w("return m.fsm")
+	w("}")
+	w("")
+	w("// Stop stops FSM of %s.", t)
+	w("func (m *%s) Stop() {", t)
+	w("m.fsm.Stop()")
+	w("}")
+	for _, e := range events {
+		w("")
+		w("// %s dispatches the event %q.", identifier(e), e)
+		w("func (m *%s) %s(ctx context.Context) error {", t, identifier(e))
+		w("return m.DispatchEvent(ctx, %sEvent%s)", t, identifier(e))
+		w("}")
+	}
+	for _, s := range targets {
+		w("")
+		w("// To%s dispatches the transition to the state %q.", identifier(s), s)
+		w("func (m *%s) To%s(ctx context.Context) error {", t, identifier(s))
+		w("return m.Dispatch(ctx, %s%s)", t, identifier(s))
+		w("}")
+	}
+
+	src, err := format.Source(b.Bytes())
+	if err != nil {
+		return nil, fmt.Errorf("format generated code: %v", err)
+	}
+	return src, nil
+}
+
+// stubRegistry returns the registry with all procedures and guards of the
+// definition to check states and events (the registry is not known at
+// generation).
+func stubRegistry(d *ffsm.Definition) *ffsm.Registry {
+	reg := ffsm.NewRegistry()
+	add := func(list []ffsm.ActionDefinition) {
+		for _, a := range list {
+			reg.AddProcedure(a.Name, nil)
+			if a.Compensate != "" {
+				reg.AddProcedure(a.Compensate, nil)
+			}
+		}
+	}
+	for _, s := range d.States {
+		add(s.Enter)
+		add(s.Exit)
+	}
+	for _, t := range d.Transitions {
+		add(t.Actions)
+		for _, g := range t.Guards {
+			reg.AddGuard(g, nil)
+		}
+	}
+	return reg
+}
+
+// definitionStates returns declared states and states of transitions (in
+// order of declaration, others are sorted).
+func definitionStates(d *ffsm.Definition) []string {
+	seen := map[string]bool{}
+	var states []string
+	for _, s := range d.States {
+		if !seen[s.Name] {
+			seen[s.Name] = true
+			states = append(states, s.Name)
+		}
+	}
+	var other []string
+	for _, s := range append([]string{d.Initial}, transitionStates(d)...) {
+		for _, p := range ffsm.StatePath(s) {
+			if p != ffsm.AnyState && !seen[p] {
+				seen[p] = true
+				other = append(other, p)
+			}
+		}
+	}
+	sort.Strings(other)
+	return append(states, other...)
+}
+
+func transitionStates(d *ffsm.Definition) []string {
+	var list []string
+	for _, t := range d.Transitions {
+		list = append(list, t.From, t.To)
+	}
+	return list
+}
+
+// identifier returns exported Go identifier of the name: words separated by
+// not letters or digits are capitalized, for example "open.ajar" is OpenAjar.
+func identifier(name string) string {
+	var b strings.Builder
+	upper := true
+	for _, r := range name {
+		if !unicode.IsLetter(r) && !unicode.IsDigit(r) {
+			upper = true
+			continue
+		}
+		if upper {
+			r = unicode.ToUpper(r)
+			upper = false
+		}
+		b.WriteRune(r)
+	}
+	s := b.String()
+	if s == "" || unicode.IsDigit(rune(s[0])) {
+		s = "S" + s
+	}
+	return s
+}
+
+// definitionLiteral returns Go literal of the definition.
+func definitionLiteral(d *ffsm.Definition) string {
+	var b strings.Builder
+	b.WriteString("&ffsm.Definition{\n")
+	if d.Name != "" {
+		fmt.Fprintf(&b, "Name: %q,\n", d.Name)
+	}
+	fmt.Fprintf(&b, "Initial: %q,\n", d.Initial)
+	if len(d.States) > 0 {
+		b.WriteString("States: []ffsm.StateDefinition{\n")
+		for _, s := range d.States {
+			fmt.Fprintf(&b, "{Name: %q", s.Name)
+			if s.Initial != "" {
+				fmt.Fprintf(&b, ", Initial: %q", s.Initial)
+			}
+			if s.Final {
+				b.WriteString(", Final: true")
+			}
+			if len(s.Enter) > 0 {
+				b.WriteString(", Enter: " + actionsLiteral(s.Enter))
+			}
+			if len(s.Exit) > 0 {
+				b.WriteString(", Exit: " + actionsLiteral(s.Exit))
+			}
+			b.WriteString("},\n")
+		}
+		b.WriteString("},\n")
+	}
+	if len(d.Transitions) > 0 {
+		b.WriteString("Transitions: []ffsm.TransitionDefinition{\n")
+		for _, t := range d.Transitions {
+			fmt.Fprintf(&b, "{From: %q, To: %q", t.From, t.To)
+			if t.Event != "" {
+				fmt.Fprintf(&b, ", Event: %q", t.Event)
+			}
+			if t.Timeout > 0 {
+				fmt.Fprintf(&b, ", Timeout: %d /* %s */", int64(t.Timeout), t.Timeout)
+			}
+			if len(t.Guards) > 0 {
+				fmt.Fprintf(&b, ", Guards: %#v", t.Guards)
+			}
+			if len(t.Actions) > 0 {
+				b.WriteString(", Actions: " + actionsLiteral(t.Actions))
+			}
+			b.WriteString("},\n")
+		}
+		b.WriteString("},\n")
+	}
+	b.WriteString("}")
+	return b.String()
+}
+
+func actionsLiteral(list []ffsm.ActionDefinition) string {
+	var parts []string
+	for _, a := range list {
+		s := fmt.Sprintf("{Name: %q", a.Name)
+		if a.Compensate != "" {
+			s += fmt.Sprintf(", Compensate: %q", a.Compensate)
+		}
+		if a.Timeout > 0 {
+			s += fmt.Sprintf(", Timeout: %d /* %s */", int64(a.Timeout), a.Timeout)
+		}
+		parts = append(parts, s+"}")
+	}
+	return "[]ffsm.ActionDefinition{" + strings.Join(parts, ", ") + "}"
+}
@@ -0,0 +1,85 @@
+package main
+
+import (
+	"io/ioutil"
+	"os/exec"
+	"testing"
+
+	"github.com/gebv/ffsm"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_Generate_Golden(t *testing.T) {
+	data, err := ioutil.ReadFile("testdata/door/door.yaml")
+	require.NoError(t, err)
+	d, err := ffsm.ParseDefinition(data)
+	require.NoError(t, err)
+
+	got, err := generate(d, options{pkg: "door", typ: identifier(d.Name), source: "door.yaml"})
+	require.NoError(t, err)
+	want, err := ioutil.ReadFile("testdata/door/door_fsm.go")
+	require.NoError(t, err)
+	assert.Equal(t, string(want), string(got), "run go generate ./cmd/ffsmgen/testdata/door")
+
+	if testing.Short() {
+		t.Skip("compile of the generated code")
+	}
+	out, err := exec.Command("go", "vet", "./testdata/door").CombinedOutput()
+	assert.NoError(t, err, string(out))
+}
+
+func Test_Generate_Errors(t *testing.T) {
+	cases := []struct {
+		name string
+		doc  string
+		want string
+	}{
+		{
+			"without initial state",
+			"transitions:\n  - {from: a, to: b}\n",
+			"initial state is not defined",
+		},
+		{
+			"unknown state",
+			"initial: a\nstates: [a, b]\ntransitions:\n  - {from: a, to: c}\n",
+			`line 4:19: transitions[0].to: unknown state "c"`,
+		},
+		{
+			"same identifiers",
+			"initial: open-door\ntransitions:\n  - {from: open-door, to: open.door}\n",
+			`state "open-door" and state "open.door" have the same identifier MOpenDoor`,
+		},
+		{
+			"state of the name of the type",
+			"initial: state\ntransitions:\n  - {from: state, to: b}\n",
+			`type of states and state "state" have the same identifier MState`,
+		},
+		{
+			"state of the name of the method",
+			"initial: a\ntransitions:\n  - {from: a, to: b, event: stop}\n",
+			`method and event "stop" have the same method Stop`,
+		},
+		{
+			"same methods",
+			"initial: a\ntransitions:\n  - {from: a, to: b, event: to-b}\n  - {from: b, to: b}\n",
+			`event "to-b" and state "b" have the same method ToB`,
+		},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			d, err := ffsm.ParseDefinition([]byte(c.doc))
+			require.NoError(t, err)
+			_, err = generate(d, options{pkg: "m", typ: "M", source: "m.yaml"})
+			require.Error(t, err)
+			assert.Equal(t, c.want, err.Error())
+		})
+	}
+}
+
+func Test_Identifier(t *testing.T) {
+	assert.Equal(t, "OpenAjar", identifier("open.ajar"))
+	assert.Equal(t, "WaitForPayment", identifier("wait_for-payment"))
+	assert.Equal(t, "S1st", identifier("1st"))
+	assert.Equal(t, "S", identifier("*"))
+}
@@ -0,0 +1,90 @@
+// Command ffsmgen generates Go code of the machine from the definition (YAML,
+// JSON or SCXML): typed constants of states and events, the constructor of
+// Stack and the typed wrapper of FSM with method for each event and
+// destination state, so invalid targets fail at compile time.
+//
+// Usage:
+//
+//	//go:generate go run github.com/gebv/ffsm/cmd/ffsmgen -in door.yaml
+//
+// For the definition of the machine "door" with state "close" and event
+// "open" it generates:
+//
+//	type DoorState string
+//	const DoorClose DoorState = "close"
+//	type DoorEvent string
+//	const DoorEventOpen DoorEvent = "open"
+//	func NewDoorStack(reg *ffsm.Registry) (ffsm.Stack, error)
+//	func NewDoor(reg *ffsm.Registry) (*Door, error)
+//	func WrapDoor(fsm *ffsm.FSM) *Door
+//	func (m *Door) State() DoorState
+//	func (m *Door) Dispatch(ctx context.Context, state DoorState) error
+//	func (m *Door) DispatchEvent(ctx context.Context, event DoorEvent) error
+//	func (m *Door) Open(ctx context.Context) error    // DispatchEvent(ctx, DoorEventOpen)
+//	func (m *Door) ToClose(ctx context.Context) error // Dispatch(ctx, DoorClose)
+package main
+
+import (
+	"flag"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/gebv/ffsm"
+)
+
+func main() {
+	in := flag.String("in", "", "file of the definition (.yaml, .yml, .json or .scxml)")
+	out := flag.String("out", "", "output file (default <in>_fsm.go)")
+	pkg := flag.String("package", os.Getenv("GOPACKAGE"), "package name (default $GOPACKAGE)")
+	typ := flag.String("type", "", "name of the type of FSM (default name of the definition or the file)")
+	flag.Parse()
+
+	log.SetFlags(0)
+	log.SetPrefix("ffsmgen: ")
+	if *in == "" {
+		flag.Usage()
+		os.Exit(2)
+	}
+	if *pkg == "" {
+		*pkg = "main"
+	}
+	base := strings.TrimSuffix(filepath.Base(*in), filepath.Ext(*in))
+	if *out == "" {
+		*out = filepath.Join(filepath.Dir(*in), base+"_fsm.go")
+	}
+
+	data, err := ioutil.ReadFile(*in)
+	if err != nil {
+		log.Fatal(err)
+	}
+	var d *ffsm.Definition
+	if strings.EqualFold(filepath.Ext(*in), ".scxml") {
+		d, err = ffsm.ParseSCXML(data)
+	} else {
+		d, err = ffsm.ParseDefinition(data)
+	}
+	if err != nil {
+		log.Fatalf("%s: %v", *in, err)
+	}
+	if *typ == "" {
+		*typ = d.Name
+	}
+	if *typ == "" {
+		*typ = base
+	}
+
+	src, err := generate(d, options{
+		pkg:    *pkg,
+		typ:    identifier(*typ),
+		source: filepath.Base(*in),
+	})
+	if err != nil {
+		log.Fatalf("%s: %v", *in, err)
+	}
+	if err := ioutil.WriteFile(*out, src, 0644); err != nil {
+		log.Fatal(err)
+	}
+}
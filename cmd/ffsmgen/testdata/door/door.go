@@ -0,0 +1,4 @@
+// Package door is the example of the code generated by ffsmgen.
+package door
+
+//go:generate go run github.com/gebv/ffsm/cmd/ffsmgen -in door.yaml
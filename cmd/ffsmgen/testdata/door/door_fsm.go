@@ -0,0 +1,112 @@
+// Code generated by ffsmgen from door.yaml. DO NOT EDIT.
+
+package door
+
+import (
+	"context"
+
+	"github.com/gebv/ffsm"
+)
+
+// DoorState is the state of Door.
+type DoorState string
+
+// States of Door.
+const (
+	DoorClose  DoorState = "close"
+	DoorOpen   DoorState = "open"
+	DoorBroken DoorState = "broken"
+)
+
+// DoorInitial is the initial state of Door.
+const DoorInitial = DoorClose
+
+// DoorEvent is the event of Door.
+type DoorEvent string
+
+// Events of Door.
+const (
+	DoorEventClose DoorEvent = "close"
+	DoorEventOpen  DoorEvent = "open"
+)
+
+var doorDefinition = &ffsm.Definition{
+	Name:    "door",
+	Initial: "close",
+	States: []ffsm.StateDefinition{
+		{Name: "close"},
+		{Name: "open", Enter: []ffsm.ActionDefinition{{Name: "log"}}},
+		{Name: "broken", Final: true},
+	},
+	Transitions: []ffsm.TransitionDefinition{
+		{From: "close", To: "open", Event: "open", Guards: []string{"onlyBob"}, Actions: []ffsm.ActionDefinition{{Name: "log"}, {Name: "charge", Compensate: "refund", Timeout: 1000000000 /* 1s */}}},
+		{From: "open", To: "close", Event: "close"},
+		{From: "*", To: "broken"},
+	},
+}
+
+// NewDoorStack returns Stack of Door with procedures and guards of the registry.
+func NewDoorStack(reg *ffsm.Registry) (ffsm.Stack, error) {
+	return doorDefinition.Build(reg)
+}
+
+// Door is FSM with methods of transitions of Door. Only states and events of
+// Door are dispatched.
+type Door struct {
+	fsm *ffsm.FSM
+}
+
+// NewDoor returns Door in the initial state with procedures and guards of the registry.
+func NewDoor(reg *ffsm.Registry) (*Door, error) {
+	wf, err := NewDoorStack(reg)
+	if err != nil {
+		return nil, err
+	}
+	return &Door{fsm: ffsm.NewFSM(wf, string(DoorInitial))}, nil
+}
+
+// WrapDoor returns Door of FSM with Stack of NewDoorStack (for example created
+// by the engine or with the store).
+func WrapDoor(fsm *ffsm.FSM) *Door {
+	return &Door{fsm: fsm}
+}
+
+// State returns current state.
+func (m *Door) State() DoorState {
+	return DoorState(m.fsm.State())
+}
+
+// Dispatch dispatches the transition to the state.
+func (m *Door) Dispatch(ctx context.Context, state DoorState) error {
+	return m.fsm.Dispatch(ctx, string(state))
+}
+
+// DispatchEvent dispatches the event.
+func (m *Door) DispatchEvent(ctx context.Context, event DoorEvent) error {
+	return m.fsm.DispatchEvent(ctx, string(event))
+}
+
+// Untyped returns FSM of Door, for example to add interceptors.
+func (m *Door) Untyped() *ffsm.FSM {
+	return m.fsm
+}
+
+// Stop stops FSM of Door.
+func (m *Door) Stop() {
+	m.fsm.Stop()
+}
+
+// Close dispatches the event "close".
+func (m *Door) Close(ctx context.Context) error {
+	return m.DispatchEvent(ctx, DoorEventClose)
+}
+
+// Open dispatches the event "open".
+func (m *Door) Open(ctx context.Context) error {
+	return m.DispatchEvent(ctx, DoorEventOpen)
+}
+
+// ToBroken dispatches the transition to the state "broken".
+func (m *Door) ToBroken(ctx context.Context) error {
+	return m.Dispatch(ctx, DoorBroken)
+}
@@ -7,19 +7,83 @@ type ctxKey uint
 const (
 	sourceStateCtxKey    ctxKey = 2
 	distanateStateCtxKey ctxKey = 3
+	eventCtxKey          ctxKey = 4
+	regionCtxKey         ctxKey = 5
+	eventMetaCtxKey      ctxKey = 6
 )
 
-func hydrateContextForAction(ctx context.Context, src, dst string) context.Context {
+func hydrateContextForAction[S comparable](ctx context.Context, src, dst S, event, region string) context.Context {
 	ctx = context.WithValue(ctx, sourceStateCtxKey, src)
-	return context.WithValue(ctx, distanateStateCtxKey, dst)
+	ctx = context.WithValue(ctx, distanateStateCtxKey, dst)
+	ctx = context.WithValue(ctx, eventCtxKey, event)
+	return context.WithValue(ctx, regionCtxKey, region)
 }
 
 // GetSrcState returns source state from context.
 func GetSrcState(ctx context.Context) string {
-	return ctx.Value(sourceStateCtxKey).(string)
+	return SrcStateOf[string](ctx)
 }
 
 // GetDstState returns destinate state from context.
 func GetDstState(ctx context.Context) string {
-	return ctx.Value(distanateStateCtxKey).(string)
+	return DstStateOf[string](ctx)
+}
+
+// SrcStateOf returns source state of FSMOf from context (zero value if the
+// state is of another type).
+func SrcStateOf[S comparable](ctx context.Context) S {
+	state, _ := ctx.Value(sourceStateCtxKey).(S)
+	return state
+}
+
+// DstStateOf returns destinate state of FSMOf from context (zero value if
+// the state is of another type).
+func DstStateOf[S comparable](ctx context.Context) S {
+	state, _ := ctx.Value(distanateStateCtxKey).(S)
+	return state
+}
+
+// GetEvent returns name of the event from context (empty if the transition
+// was dispatched by the destination state).
+func GetEvent(ctx context.Context) string {
+	event, _ := ctx.Value(eventCtxKey).(string)
+	return event
+}
+
+// GetRegion returns name of the region of transition from context
+// (MainRegion for the main state of FSM).
+func GetRegion(ctx context.Context) string {
+	region, _ := ctx.Value(regionCtxKey).(string)
+	return region
+}
+
+// valueContext is the context with values of values and with deadline and
+// cancellation of parent context.
+type valueContext struct {
+	context.Context
+	values context.Context
+}
+
+func (c valueContext) Value(key interface{}) interface{} {
+	return c.values.Value(key)
+}
+
+// WithEventMeta returns context with metadata of the event which is written
+// to the journal with the transition (see Journal). Metadata of the context is
+// merged with the metadata of parent context.
+func WithEventMeta(ctx context.Context, meta map[string]string) context.Context {
+	merged := make(map[string]string, len(meta))
+	for k, v := range GetEventMeta(ctx) {
+		merged[k] = v
+	}
+	for k, v := range meta {
+		merged[k] = v
+	}
+	return context.WithValue(ctx, eventMetaCtxKey, merged)
+}
+
+// GetEventMeta returns metadata of the event from context.
+func GetEventMeta(ctx context.Context) map[string]string {
+	meta, _ := ctx.Value(eventMetaCtxKey).(map[string]string)
+	return meta
 }
@@ -0,0 +1,601 @@
+package ffsm
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Definition is the declarative definition of the machine loaded from YAML
+// or JSON document by ParseDefinition. Procedures and guards are referenced
+// by names of Registry.
+//
+//	name: door
+//	initial: closed
+//	states:
+//	  - closed
+//	  - name: opened
+//	    enter: [notify]
+//	  - name: broken
+//	    final: true
+//	transitions:
+//	  - from: closed
+//	    to: opened
+//	    event: open
+//	    timeout: 5s
+//	    guards: [onlyBob]
+//	    actions:
+//	      - log
+//	      - name: charge
+//	        compensate: refund
+//	        timeout: 1s
+//	  - from: "*"
+//	    to: broken
+type Definition struct {
+	Name        string
+	Initial     string
+	States      []StateDefinition // empty is without check of states
+	Transitions []TransitionDefinition
+
+	pos map[string]position // positions of fields by path (for example "transitions[0].to")
+}
+
+// StateDefinition is the definition of the state.
+type StateDefinition struct {
+	Name    string
+	Initial string // initial child state
+	Final   bool
+	Enter   []ActionDefinition
+	Exit    []ActionDefinition
+}
+
+// TransitionDefinition is the definition of the transition.
+type TransitionDefinition struct {
+	From    string
+	To      string
+	Event   string        // optional
+	Timeout time.Duration // timeout of the transition (see Stack.SetTimeout)
+	Guards  []string
+	Actions []ActionDefinition
+}
+
+// ActionDefinition is the definition of the action of the transition or
+// the hook of the state.
+type ActionDefinition struct {
+	Name       string // name of the procedure
+	Compensate string // name of the procedure (optional)
+	Timeout    time.Duration
+}
+
+type position struct {
+	line, column int
+}
+
+// DefinitionError is the error of the definition with position in the document.
+type DefinitionError struct {
+	Line   int // zero if unknown
+	Column int
+	Field  string // path of the field, for example transitions[1].actions[0]
+	Err    error
+}
+
+func (e DefinitionError) Error() string {
+	s := ""
+	if e.Line > 0 {
+		s = fmt.Sprintf("line %d:%d: ", e.Line, e.Column)
+	}
+	if e.Field != "" {
+		s += e.Field + ": "
+	}
+	return s + e.Err.Error()
+}
+
+// Unwrap returns the error of the definition.
+func (e DefinitionError) Unwrap() error {
+	return e.Err
+}
+
+// Is reports whether target is ErrInvalidDefinition.
+func (e DefinitionError) Is(target error) bool {
+	return target == ErrInvalidDefinition
+}
+
+// NewRegistry returns empty registry of named procedures and guards.
+func NewRegistry() *Registry {
+	return &Registry{
+		procedures: map[string]Procedure{},
+		guards:     map[string]Guard{},
+	}
+}
+
+// Registry of named procedures and guards for definitions.
+type Registry struct {
+	procedures map[string]Procedure
+	guards     map[string]Guard
+}
+
+// AddProcedure registration procedure by name.
+func (r *Registry) AddProcedure(name string, p Procedure) *Registry {
+	if name == "" {
+		panic("Registry.AddProcedure: name is empty")
+	}
+	r.procedures[name] = p
+	return r
+}
+
+// AddGuard registration guard by name.
+func (r *Registry) AddGuard(name string, g Guard) *Registry {
+	if name == "" {
+		panic("Registry.AddGuard: name is empty")
+	}
+	r.guards[name] = g
+	return r
+}
+
+// Procedure returns procedure by name.
+func (r *Registry) Procedure(name string) (Procedure, bool) {
+	p, ok := r.procedures[name]
+	return p, ok
+}
+
+// Guard returns guard by name.
+func (r *Registry) Guard(name string) (Guard, bool) {
+	g, ok := r.guards[name]
+	return g, ok
+}
+
+// ParseDefinition parses the definition from YAML or JSON document.
+// Returns DefinitionError with position of the wrong field.
+func ParseDefinition(data []byte) (*Definition, error) {
+	var doc yaml.Node
+	if err := yaml.Unmarshal(data, &doc); err != nil {
+		return nil, DefinitionError{Err: err}
+	}
+	d := &Definition{pos: map[string]position{}}
+	if len(doc.Content) == 0 {
+		return nil, DefinitionError{Err: errors.New("document is empty")}
+	}
+	p := &definitionParser{d: d}
+	p.definition(doc.Content[0])
+	if p.err != nil {
+		return nil, p.err
+	}
+	return d, nil
+}
+
+// Build returns Stack of the definition with procedures and guards of the
+// registry. Names of actions are names of procedures. Returns DefinitionError
+// with position of unknown names of procedures, guards or states.
+func (d *Definition) Build(reg *Registry) (Stack, error) {
+	if reg == nil {
+		reg = NewRegistry()
+	}
+	declared := map[string]bool{}
+	for _, s := range d.States {
+		for _, p := range StatePath(s.Name) {
+			declared[p] = true
+		}
+	}
+	checkState := func(field, state string) error {
+		if len(d.States) == 0 || state == AnyState || declared[state] {
+			return nil
+		}
+		return d.errorf(field, "unknown state %q", state)
+	}
+	if err := checkState("initial", d.Initial); d.Initial != "" && err != nil {
+		return nil, err
+	}
+
+	wf := make(Stack)
+	for i, s := range d.States {
+		field := fmt.Sprintf("states[%d]", i)
+		if s.Initial != "" {
+			if err := checkState(field+".initial", s.Initial); err != nil {
+				return nil, err
+			}
+			if !isDescendant(s.Initial, s.Name) {
+				return nil, d.errorf(field+".initial", "state %q is not child of %q", s.Initial, s.Name)
+			}
+			wf.SetInitial(s.Name, s.Initial)
+		}
+		enter, err := d.actions(reg, field+".enter", s.Enter)
+		if err != nil {
+			return nil, err
+		}
+		exit, err := d.actions(reg, field+".exit", s.Exit)
+		if err != nil {
+			return nil, err
+		}
+		if len(enter) > 0 {
+			wf.add(StackKey{Dst: s.Name, Kind: EnterKind}, enter...)
+		}
+		if len(exit) > 0 {
+			wf.add(StackKey{Src: s.Name, Kind: ExitKind}, exit...)
+		}
+	}
+
+	events := map[StackKey]string{} // {src, event} => dst
+	for i, t := range d.Transitions {
+		field := fmt.Sprintf("transitions[%d]", i)
+		if err := checkState(field+".from", t.From); err != nil {
+			return nil, err
+		}
+		if err := checkState(field+".to", t.To); err != nil {
+			return nil, err
+		}
+		k := StackKey{Src: t.From, Dst: t.To, Event: t.Event}
+		if t.Event != "" {
+			if t.To == AnyState {
+				return nil, d.errorf(field+".to", "destination state of event can not be any state")
+			}
+			e := StackKey{Src: t.From, Event: t.Event}
+			if dst, ok := events[e]; ok && dst != t.To {
+				return nil, d.errorf(field+".event", "event %q from %q already registered to %q", t.Event, t.From, dst)
+			}
+			events[e] = t.To
+		}
+
+		actions := make([]Action, 0, len(t.Guards)+len(t.Actions))
+		for j, name := range t.Guards {
+			g, ok := reg.Guard(name)
+			if !ok {
+				return nil, d.errorf(fmt.Sprintf("%s.guards[%d]", field, j), "unknown guard %q", name)
+			}
+			actions = append(actions, Action{Name: name, Procedure: g.procedure(), Guard: true})
+		}
+		list, err := d.actions(reg, field+".actions", t.Actions)
+		if err != nil {
+			return nil, err
+		}
+		wf.add(k, append(actions, list...)...)
+		if t.Timeout > 0 && t.Event != "" {
+			wf.SetEventTimeout(t.From, t.Event, t.Timeout)
+		} else if t.Timeout > 0 {
+			wf.SetTimeout(t.From, t.To, t.Timeout)
+		}
+	}
+	return wf, nil
+}
+
+// NewDefinition returns the definition of the stack with the initial state.
+// Procedures and guards are referenced by names of actions, so each action
+// with procedure must have the name. Compensations can not be referenced.
+// States and transitions are sorted.
+func NewDefinition(wf Stack, initState string) (*Definition, error) {
+	if wf == nil {
+		panic("NewDefinition: stack is empty")
+	}
+	d := &Definition{Initial: initState, pos: map[string]position{}}
+
+	states := map[string]*StateDefinition{}
+	var names []string
+	state := func(name string) *StateDefinition {
+		if name == UnknownState || name == AnyState {
+			return nil
+		}
+		for _, p := range StatePath(name) {
+			if states[p] == nil {
+				states[p] = &StateDefinition{Name: p}
+				names = append(names, p)
+			}
+		}
+		return states[name]
+	}
+	state(initState)
+
+	var keys []StackKey
+	for k := range wf {
+		switch k.Kind {
+		case TransitionKind:
+			keys = append(keys, k)
+		case InitialKind:
+			state(k.Dst)
+		}
+		state(k.Src)
+		state(k.Dst)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		a, b := keys[i], keys[j]
+		if a.Src != b.Src {
+			return a.Src < b.Src
+		}
+		if a.Dst != b.Dst {
+			return a.Dst < b.Dst
+		}
+		return a.Event < b.Event
+	})
+	sort.Strings(names)
+
+	for _, name := range names {
+		s := states[name]
+		if child, ok := wf.initialChild(name); ok {
+			s.Initial = child
+		}
+		var err error
+		field := fmt.Sprintf("states[%d]", len(d.States))
+		if s.Enter, err = definitionActions(field+".enter", wf[StackKey{Dst: name, Kind: EnterKind}]); err != nil {
+			return nil, err
+		}
+		if s.Exit, err = definitionActions(field+".exit", wf[StackKey{Src: name, Kind: ExitKind}]); err != nil {
+			return nil, err
+		}
+		d.States = append(d.States, *s)
+	}
+	for i, k := range keys {
+		t := TransitionDefinition{From: k.Src, To: k.Dst, Event: k.Event}
+		t.Timeout, _ = wf.Timeout(k)
+		field := fmt.Sprintf("transitions[%d]", i)
+		actions := wf[k]
+		for len(actions) > 0 && actions[0].Guard {
+			if actions[0].Name == "" {
+				return nil, DefinitionError{Field: fmt.Sprintf("%s.guards[%d]", field, len(t.Guards)), Err: errors.New("guard without name")}
+			}
+			t.Guards = append(t.Guards, actions[0].Name)
+			actions = actions[1:]
+		}
+		var err error
+		if t.Actions, err = definitionActions(field+".actions", actions); err != nil {
+			return nil, err
+		}
+		d.Transitions = append(d.Transitions, t)
+	}
+	return d, nil
+}
+
+func definitionActions(field string, actions []Action) ([]ActionDefinition, error) {
+	var list []ActionDefinition
+	for i, a := range actions {
+		if a.empty() {
+			continue
+		}
+		field := fmt.Sprintf("%s[%d]", field, i)
+		if a.Name == "" {
+			return nil, DefinitionError{Field: field, Err: errors.New("procedure without name")}
+		}
+		if a.compensation() != nil {
+			return nil, DefinitionError{Field: field + ".compensate", Err: errors.New("compensation can not be referenced by name")}
+		}
+		list = append(list, ActionDefinition{Name: a.Name, Timeout: a.Timeout})
+	}
+	return list, nil
+}
+
+// Finals returns names of final states.
+func (d *Definition) Finals() []string {
+	var finals []string
+	for _, s := range d.States {
+		if s.Final {
+			finals = append(finals, s.Name)
+		}
+	}
+	return finals
+}
+
+// StateNames returns names of declared states.
+func (d *Definition) StateNames() []string {
+	names := make([]string, 0, len(d.States))
+	for _, s := range d.States {
+		names = append(names, s.Name)
+	}
+	return names
+}
+
+func (d *Definition) actions(reg *Registry, field string, list []ActionDefinition) ([]Action, error) {
+	actions := make([]Action, 0, len(list))
+	for i, a := range list {
+		field := fmt.Sprintf("%s[%d]", field, i)
+		p, ok := reg.Procedure(a.Name)
+		if !ok {
+			return nil, d.errorf(field, "unknown procedure %q", a.Name)
+		}
+		action := Action{Name: a.Name, Procedure: p, Timeout: a.Timeout}
+		if a.Compensate != "" {
+			action.Compensate, ok = reg.Procedure(a.Compensate)
+			if !ok {
+				return nil, d.errorf(field+".compensate", "unknown procedure %q", a.Compensate)
+			}
+		}
+		actions = append(actions, action)
+	}
+	return actions, nil
+}
+
+// errorf returns DefinitionError with position of the field.
+func (d *Definition) errorf(field, format string, args ...interface{}) error {
+	pos := d.pos[field]
+	return DefinitionError{Line: pos.line, Column: pos.column, Field: field, Err: fmt.Errorf(format, args...)}
+}
+
+// definitionParser parses nodes of the document. The first error is kept.
+type definitionParser struct {
+	d   *Definition
+	err error
+}
+
+func (p *definitionParser) errorf(n *yaml.Node, field, format string, args ...interface{}) {
+	if p.err == nil {
+		p.err = DefinitionError{Line: n.Line, Column: n.Column, Field: field, Err: fmt.Errorf(format, args...)}
+	}
+}
+
+// fields calls fn for each field of the mapping and checks names of fields.
+func (p *definitionParser) fields(n *yaml.Node, field string, names []string, fn func(name string, value *yaml.Node)) {
+	if n.Kind != yaml.MappingNode {
+		p.errorf(n, field, "expected mapping")
+		return
+	}
+	p.d.pos[field] = position{n.Line, n.Column}
+	seen := map[string]bool{}
+	for i := 0; i+1 < len(n.Content) && p.err == nil; i += 2 {
+		key, value := n.Content[i], n.Content[i+1]
+		known := false
+		for _, name := range names {
+			known = known || name == key.Value
+		}
+		sub := key.Value
+		if field != "" {
+			sub = field + "." + key.Value
+		}
+		switch {
+		case !known:
+			p.errorf(key, sub, "unknown field")
+		case seen[key.Value]:
+			p.errorf(key, sub, "duplicate field")
+		default:
+			seen[key.Value] = true
+			p.d.pos[sub] = position{value.Line, value.Column}
+			fn(key.Value, value)
+		}
+	}
+}
+
+func (p *definitionParser) scalar(n *yaml.Node, field string) string {
+	if n.Kind != yaml.ScalarNode {
+		p.errorf(n, field, "expected string")
+		return ""
+	}
+	p.d.pos[field] = position{n.Line, n.Column}
+	return n.Value
+}
+
+func (p *definitionParser) name(n *yaml.Node, field string) string {
+	s := p.scalar(n, field)
+	if s == "" && p.err == nil {
+		p.errorf(n, field, "empty name")
+	}
+	return s
+}
+
+func (p *definitionParser) sequence(n *yaml.Node, field string, fn func(field string, item *yaml.Node)) {
+	if n.Kind != yaml.SequenceNode {
+		p.errorf(n, field, "expected list")
+		return
+	}
+	for i, item := range n.Content {
+		if p.err != nil {
+			return
+		}
+		sub := fmt.Sprintf("%s[%d]", field, i)
+		p.d.pos[sub] = position{item.Line, item.Column}
+		fn(sub, item)
+	}
+}
+
+func (p *definitionParser) definition(n *yaml.Node) {
+	p.fields(n, "", []string{"name", "initial", "states", "transitions"}, func(name string, v *yaml.Node) {
+		switch name {
+		case "name":
+			p.d.Name = p.scalar(v, name)
+		case "initial":
+			p.d.Initial = p.name(v, name)
+		case "states":
+			p.sequence(v, name, func(field string, item *yaml.Node) {
+				p.d.States = append(p.d.States, p.state(item, field))
+			})
+		case "transitions":
+			p.sequence(v, name, func(field string, item *yaml.Node) {
+				p.d.Transitions = append(p.d.Transitions, p.transition(item, field))
+			})
+		}
+	})
+}
+
+func (p *definitionParser) state(n *yaml.Node, field string) StateDefinition {
+	var s StateDefinition
+	if n.Kind == yaml.ScalarNode {
+		s.Name = p.name(n, field)
+		return s
+	}
+	p.fields(n, field, []string{"name", "initial", "final", "enter", "exit"}, func(name string, v *yaml.Node) {
+		sub := field + "." + name
+		switch name {
+		case "name":
+			s.Name = p.name(v, sub)
+		case "initial":
+			s.Initial = p.name(v, sub)
+		case "final":
+			var err error
+			s.Final, err = strconv.ParseBool(p.scalar(v, sub))
+			if err != nil {
+				p.errorf(v, sub, "expected bool")
+			}
+		case "enter":
+			s.Enter = p.actions(v, sub)
+		case "exit":
+			s.Exit = p.actions(v, sub)
+		}
+	})
+	if s.Name == "" {
+		p.errorf(n, field+".name", "required field")
+	}
+	return s
+}
+
+func (p *definitionParser) transition(n *yaml.Node, field string) TransitionDefinition {
+	var t TransitionDefinition
+	p.fields(n, field, []string{"from", "to", "event", "timeout", "guards", "actions"}, func(name string, v *yaml.Node) {
+		sub := field + "." + name
+		switch name {
+		case "from":
+			t.From = p.name(v, sub)
+		case "to":
+			t.To = p.name(v, sub)
+		case "event":
+			t.Event = p.name(v, sub)
+		case "timeout":
+			var err error
+			t.Timeout, err = time.ParseDuration(p.scalar(v, sub))
+			if err != nil {
+				p.errorf(v, sub, "expected duration")
+			}
+		case "guards":
+			p.sequence(v, sub, func(field string, item *yaml.Node) {
+				t.Guards = append(t.Guards, p.name(item, field))
+			})
+		case "actions":
+			t.Actions = p.actions(v, sub)
+		}
+	})
+	for _, required := range []struct{ name, value string }{{"from", t.From}, {"to", t.To}} {
+		if required.value == "" {
+			p.errorf(n, field+"."+required.name, "required field")
+		}
+	}
+	return t
+}
+
+func (p *definitionParser) actions(n *yaml.Node, field string) []ActionDefinition {
+	var list []ActionDefinition
+	p.sequence(n, field, func(field string, item *yaml.Node) {
+		var a ActionDefinition
+		if item.Kind == yaml.ScalarNode {
+			a.Name = p.name(item, field)
+			list = append(list, a)
+			return
+		}
+		p.fields(item, field, []string{"name", "compensate", "timeout"}, func(name string, v *yaml.Node) {
+			sub := field + "." + name
+			switch name {
+			case "name":
+				a.Name = p.name(v, sub)
+			case "compensate":
+				a.Compensate = p.name(v, sub)
+			case "timeout":
+				var err error
+				a.Timeout, err = time.ParseDuration(p.scalar(v, sub))
+				if err != nil {
+					p.errorf(v, sub, "expected duration")
+				}
+			}
+		})
+		if a.Name == "" {
+			p.errorf(item, field+".name", "required field")
+		}
+		list = append(list, a)
+	})
+	return list
+}
@@ -0,0 +1,193 @@
+package ffsm
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const doorDefinition = `
+name: door
+initial: close
+states:
+  - close
+  - name: open
+    enter: [log]
+  - name: broken
+    final: true
+transitions:
+  - from: close
+    to: open
+    event: open
+    guards: [onlyBob]
+    actions:
+      - log
+      - name: charge
+        compensate: refund
+        timeout: 1s
+  - from: open
+    to: close
+    timeout: 5s
+  - from: "*"
+    to: broken
+`
+
+func doorRegistry(calls *[]string) *Registry {
+	procedure := func(name string) Procedure {
+		return func(ctx context.Context) (context.Context, error) {
+			*calls = append(*calls, name)
+			return ctx, nil
+		}
+	}
+	return NewRegistry().
+		AddProcedure("log", procedure("log")).
+		AddProcedure("charge", procedure("charge")).
+		AddProcedure("refund", procedure("refund")).
+		AddGuard("onlyBob", func(ctx context.Context) error {
+			if ctx.Value("__name") != "bob" {
+				return errors.New("only bob")
+			}
+			return nil
+		})
+}
+
+func Test_Definition_YAML(t *testing.T) {
+	d, err := ParseDefinition([]byte(doorDefinition))
+	require.NoError(t, err)
+	assert.Equal(t, "door", d.Name)
+	assert.Equal(t, CloseDoor, d.Initial)
+	assert.Equal(t, []string{"close", "open", "broken"}, d.StateNames())
+	assert.Equal(t, []string{"broken"}, d.Finals())
+	assert.Equal(t, ActionDefinition{Name: "charge", Compensate: "refund", Timeout: time.Second}, d.Transitions[0].Actions[1])
+
+	var calls []string
+	wf, err := d.Build(doorRegistry(&calls))
+	require.NoError(t, err)
+	assert.True(t, wf.Validate(d.Initial, d.StateNames(), d.Finals()).OK())
+
+	actions := wf[StackKey{Src: CloseDoor, Dst: OpenDoor, Event: "open"}]
+	require.Len(t, actions, 3)
+	assert.Equal(t, "onlyBob", actions[0].Name)
+	assert.True(t, actions[0].Guard)
+	assert.Equal(t, "charge", actions[2].Name)
+	assert.Equal(t, time.Second, actions[2].Timeout)
+	assert.NotNil(t, actions[2].Compensate)
+	timeout, ok := wf.Timeout(StackKey{Src: OpenDoor, Dst: CloseDoor})
+	assert.True(t, ok)
+	assert.Equal(t, 5*time.Second, timeout)
+
+	exported, err := NewDefinition(make(Stack).Add(OpenDoor, CloseDoor).SetTimeout(OpenDoor, CloseDoor, 5*time.Second), OpenDoor)
+	require.NoError(t, err)
+	assert.Equal(t, []TransitionDefinition{{From: OpenDoor, To: CloseDoor, Timeout: 5 * time.Second}}, exported.Transitions)
+
+	fsm := NewFSM(wf, d.Initial)
+	defer fsm.Stop()
+	err = fsm.DispatchEvent(context.Background(), "open")
+	assert.True(t, errors.Is(err, ErrGuardRejected))
+	err = fsm.DispatchEvent(context.WithValue(context.Background(), "__name", "bob"), "open")
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"log", "charge", "log"}, calls)
+	assert.NoError(t, fsm.Dispatch(context.Background(), "broken"))
+}
+
+func Test_Definition_JSON(t *testing.T) {
+	d, err := ParseDefinition([]byte(`{
+  "initial": "close",
+  "transitions": [
+    {"from": "close", "to": "open", "actions": ["log"]},
+    {"from": "open", "to": "close"}
+  ]
+}`))
+	require.NoError(t, err)
+
+	var calls []string
+	wf, err := d.Build(doorRegistry(&calls))
+	require.NoError(t, err)
+	assert.Len(t, wf.Get(CloseDoor, OpenDoor), 1)
+	assert.Len(t, wf.Get(OpenDoor, CloseDoor), 0)
+}
+
+func Test_Definition_Errors(t *testing.T) {
+	cases := []struct {
+		name string
+		doc  string
+		want string
+	}{
+		{
+			"unknown field",
+			"initial: close\ntransitions:\n  - from: close\n    too: open\n",
+			"line 4:5: transitions[0].too: unknown field",
+		},
+		{
+			"required field",
+			"transitions:\n  - from: close\n",
+			"line 2:5: transitions[0].to: required field",
+		},
+		{
+			"wrong type",
+			"states:\n  - name: close\n    final: maybe\n",
+			"line 3:12: states[0].final: expected bool",
+		},
+		{
+			"syntax",
+			"states: [close\n",
+			"yaml: line 1: did not find expected ',' or ']'",
+		},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			_, err := ParseDefinition([]byte(c.doc))
+			require.Error(t, err)
+			assert.True(t, errors.Is(err, ErrInvalidDefinition))
+			assert.Equal(t, c.want, err.Error())
+		})
+	}
+}
+
+func Test_Definition_BuildErrors(t *testing.T) {
+	cases := []struct {
+		name string
+		doc  string
+		want string
+	}{
+		{
+			"unknown procedure",
+			"transitions:\n  - from: close\n    to: open\n    actions: [log, send]\n",
+			`line 4:20: transitions[0].actions[1]: unknown procedure "send"`,
+		},
+		{
+			"unknown compensation",
+			"transitions:\n  - from: close\n    to: open\n    actions:\n      - name: charge\n        compensate: undo\n",
+			`line 6:21: transitions[0].actions[0].compensate: unknown procedure "undo"`,
+		},
+		{
+			"unknown guard",
+			"transitions:\n  - from: close\n    to: open\n    guards: [onlyAlice]\n",
+			`line 4:14: transitions[0].guards[0]: unknown guard "onlyAlice"`,
+		},
+		{
+			"unknown state",
+			"states: [close, open]\ntransitions:\n  - from: close\n    to: opne\n",
+			`line 4:9: transitions[0].to: unknown state "opne"`,
+		},
+		{
+			"conflict of event",
+			"transitions:\n  - {from: close, to: open, event: open}\n  - {from: close, to: broken, event: open}\n",
+			`line 3:38: transitions[1].event: event "open" from "close" already registered to "open"`,
+		},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			d, err := ParseDefinition([]byte(c.doc))
+			require.NoError(t, err)
+			_, err = d.Build(doorRegistry(new([]string)))
+			require.Error(t, err)
+			assert.True(t, errors.Is(err, ErrInvalidDefinition))
+			assert.Equal(t, c.want, err.Error())
+		})
+	}
+}
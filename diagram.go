@@ -0,0 +1,210 @@
+package ffsm
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// DiagramOptions options of diagrams of Stack. States are names of states
+// (see StateName).
+type DiagramOptions struct {
+	// Initial state is marked by the start point (optional).
+	Initial string
+	// Current state is highlighted, for example FSM.State() (optional).
+	Current string
+	// Names labels edges by names of actions instead of number of procedures.
+	// Guards are in brackets, actions without name are labeled by index.
+	Names bool
+}
+
+// DOT returns Graphviz DOT diagram of transitions of the stack.
+func (r StackOf[S, P]) DOT(opts DiagramOptions) string {
+	if r == nil {
+		panic("Stack.DOT: stack is empty")
+	}
+	d := newDiagram(r.names(), opts)
+	var b strings.Builder
+	b.WriteString("digraph fsm {\n")
+	b.WriteString("\trankdir=LR;\n")
+	if d.initial != "" {
+		b.WriteString("\t__start [shape=point];\n")
+	}
+	for _, s := range d.states {
+		if s == opts.Current {
+			fmt.Fprintf(&b, "\t%q [style=filled, fillcolor=lightblue];\n", s)
+			continue
+		}
+		fmt.Fprintf(&b, "\t%q;\n", s)
+	}
+	if d.initial != "" {
+		fmt.Fprintf(&b, "\t__start -> %q;\n", d.initial)
+	}
+	for _, e := range d.edges {
+		if e.label == "" {
+			fmt.Fprintf(&b, "\t%q -> %q;\n", e.src, e.dst)
+			continue
+		}
+		fmt.Fprintf(&b, "\t%q -> %q [label=%q];\n", e.src, e.dst, e.label)
+	}
+	b.WriteString("}\n")
+	return b.String()
+}
+
+// Mermaid returns Mermaid stateDiagram-v2 diagram of transitions of the stack.
+func (r StackOf[S, P]) Mermaid(opts DiagramOptions) string {
+	if r == nil {
+		panic("Stack.Mermaid: stack is empty")
+	}
+	d := newDiagram(r.names(), opts)
+	var b strings.Builder
+	b.WriteString("stateDiagram-v2\n")
+	for _, s := range d.states {
+		fmt.Fprintf(&b, "\tstate \"%s\" as %s\n", diagramLabel(s), d.ids[s])
+	}
+	if d.initial != "" {
+		fmt.Fprintf(&b, "\t[*] --> %s\n", d.ids[d.initial])
+	}
+	for _, e := range d.edges {
+		if e.label == "" {
+			fmt.Fprintf(&b, "\t%s --> %s\n", d.ids[e.src], d.ids[e.dst])
+			continue
+		}
+		fmt.Fprintf(&b, "\t%s --> %s : %s\n", d.ids[e.src], d.ids[e.dst], diagramLabel(e.label))
+	}
+	if id, ok := d.ids[opts.Current]; ok {
+		b.WriteString("\tclassDef current fill:lightblue\n")
+		fmt.Fprintf(&b, "\tclass %s current\n", id)
+	}
+	return b.String()
+}
+
+// PlantUML returns PlantUML state diagram of transitions of the stack.
+func (r StackOf[S, P]) PlantUML(opts DiagramOptions) string {
+	if r == nil {
+		panic("Stack.PlantUML: stack is empty")
+	}
+	d := newDiagram(r.names(), opts)
+	var b strings.Builder
+	b.WriteString("@startuml\n")
+	for _, s := range d.states {
+		fmt.Fprintf(&b, "state \"%s\" as %s", diagramLabel(s), d.ids[s])
+		if s == opts.Current {
+			b.WriteString(" #lightblue")
+		}
+		b.WriteString("\n")
+	}
+	if d.initial != "" {
+		fmt.Fprintf(&b, "[*] --> %s\n", d.ids[d.initial])
+	}
+	for _, e := range d.edges {
+		if e.label == "" {
+			fmt.Fprintf(&b, "%s --> %s\n", d.ids[e.src], d.ids[e.dst])
+			continue
+		}
+		fmt.Fprintf(&b, "%s --> %s : %s\n", d.ids[e.src], d.ids[e.dst], diagramLabel(e.label))
+	}
+	b.WriteString("@enduml\n")
+	return b.String()
+}
+
+type diagram struct {
+	states  []string          // sorted states
+	ids     map[string]string // identifiers of states for Mermaid and PlantUML
+	initial string
+	edges   []diagramEdge
+}
+
+type diagramEdge struct {
+	src, dst, label string
+}
+
+// newDiagram returns states and edges of transitions of the stack in stable
+// order. Hooks and initial child states are not drawn.
+func newDiagram(r Stack, opts DiagramOptions) diagram {
+	var keys []StackKey
+	known := map[string]bool{}
+	for k := range r {
+		if k.Kind != TransitionKind {
+			continue
+		}
+		keys = append(keys, k)
+		known[k.Src] = true
+		known[k.Dst] = true
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		a, b := keys[i], keys[j]
+		if a.Src != b.Src {
+			return a.Src < b.Src
+		}
+		if a.Dst != b.Dst {
+			return a.Dst < b.Dst
+		}
+		return a.Event < b.Event
+	})
+
+	d := diagram{ids: map[string]string{}}
+	if opts.Initial != UnknownState {
+		d.initial = r.initial(opts.Initial)
+		known[d.initial] = true
+	}
+	if opts.Current != UnknownState {
+		known[opts.Current] = true
+	}
+	for s := range known {
+		d.states = append(d.states, s)
+	}
+	sort.Strings(d.states)
+	for i, s := range d.states {
+		d.ids[s] = fmt.Sprintf("s%d", i)
+	}
+
+	for _, k := range keys {
+		d.edges = append(d.edges, diagramEdge{src: k.Src, dst: k.Dst, label: edgeLabel(k, r[k], opts.Names)})
+	}
+	return d
+}
+
+// edgeLabel returns label of the transition: the event and number of
+// procedures (or names of actions) separated by slash.
+func edgeLabel(k StackKey, actions []Action, names bool) string {
+	var parts []string
+	if k.Event != "" {
+		parts = append(parts, k.Event)
+	}
+	if names {
+		var list []string
+		for i, a := range actions {
+			if a.empty() {
+				continue
+			}
+			name := a.Name
+			if name == "" {
+				name = fmt.Sprintf("#%d", i)
+			}
+			if a.Guard {
+				name = "[" + name + "]"
+			}
+			list = append(list, name)
+		}
+		if len(list) > 0 {
+			parts = append(parts, strings.Join(list, ", "))
+		}
+	} else {
+		n := 0
+		for _, a := range actions {
+			if !a.empty() {
+				n++
+			}
+		}
+		if n > 0 {
+			parts = append(parts, fmt.Sprintf("(%d)", n))
+		}
+	}
+	return strings.Join(parts, " / ")
+}
+
+// diagramLabel escapes the label for Mermaid and PlantUML.
+func diagramLabel(s string) string {
+	return strings.NewReplacer(`"`, `'`, "\n", " ").Replace(s)
+}
@@ -0,0 +1,71 @@
+package ffsm
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func diagramStack() Stack {
+	h := func(ctx context.Context) (context.Context, error) { return ctx, nil }
+	return make(Stack).
+		Add(CloseDoor, OpenDoor, h, h).
+		Add(OpenDoor, CloseDoor, nil).
+		AddEventAction(CloseDoor, "knock", TokTokDoor, Action{Name: "knock", Procedure: h}).
+		AddGuard(CloseDoor, OpenDoor, func(ctx context.Context) error { return nil })
+}
+
+func Test_Stack_DOT(t *testing.T) {
+	assert.Equal(t, `digraph fsm {
+	rankdir=LR;
+	__start [shape=point];
+	"close";
+	"open" [style=filled, fillcolor=lightblue];
+	"toktok";
+	__start -> "close";
+	"close" -> "open" [label="(3)"];
+	"close" -> "toktok" [label="knock / (1)"];
+	"open" -> "close";
+}
+`, diagramStack().DOT(DiagramOptions{Initial: CloseDoor, Current: OpenDoor}))
+}
+
+func Test_Stack_Mermaid(t *testing.T) {
+	assert.Equal(t, `stateDiagram-v2
+	state "close" as s0
+	state "open" as s1
+	state "toktok" as s2
+	[*] --> s0
+	s0 --> s1 : [#0], #1, #2
+	s0 --> s2 : knock / knock
+	s1 --> s0
+	classDef current fill:lightblue
+	class s1 current
+`, diagramStack().Mermaid(DiagramOptions{Initial: CloseDoor, Current: OpenDoor, Names: true}))
+
+	// without initial and current states
+	assert.Equal(t, `stateDiagram-v2
+	state "close" as s0
+	state "open" as s1
+	state "toktok" as s2
+	s0 --> s1 : (3)
+	s0 --> s2 : knock / (1)
+	s1 --> s0
+`, diagramStack().Mermaid(DiagramOptions{}))
+}
+
+func Test_Stack_PlantUML(t *testing.T) {
+	fsm := NewFSM(diagramStack(), CloseDoor)
+	defer fsm.Stop()
+	assert.Equal(t, `@startuml
+state "close" as s0 #lightblue
+state "open" as s1
+state "toktok" as s2
+[*] --> s0
+s0 --> s1 : (3)
+s0 --> s2 : knock / (1)
+s1 --> s0
+@enduml
+`, diagramStack().PlantUML(DiagramOptions{Initial: CloseDoor, Current: fsm.State()}))
+}
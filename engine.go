@@ -0,0 +1,281 @@
+package ffsm
+
+import (
+	"context"
+	"hash/fnv"
+	"runtime"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// engineBatch is the max number of messages of the FSM processed by the
+// worker at once. Other FSM of the shard wait no longer than the batch.
+const engineBatch = 32
+
+// NewEngine returns the engine with the fixed number of workers (zero is
+// runtime.GOMAXPROCS).
+//
+// FSM created by the engine have no own goroutine and queue. The messages
+// are queued in the mailbox of FSM and are processed by the worker of the
+// shard of FSM in order of dispatch, so ordering is strict for each FSM and
+// the idle FSM costs only its memory.
+//
+// NOTE: the worker executes procedures of the transition itself, so the
+// procedure that hangs stalls all FSM of the shard. Set Timeout of actions
+// or SetTransitionTimeout of FSM if procedures can block.
+func NewEngine(workers int) *Engine {
+	if workers <= 0 {
+		workers = runtime.GOMAXPROCS(0)
+	}
+	g := &Engine{
+		shards:  make([]*engineShard, workers),
+		metrics: newMetrics(),
+	}
+	for i := range g.shards {
+		s := &engineShard{}
+		s.cond = sync.NewCond(&s.mu)
+		g.shards[i] = s
+		g.wg.Add(1)
+		go g.runWorker(s)
+	}
+	return g
+}
+
+// Engine the pool of workers of many FSM.
+type Engine struct {
+	shards []*engineShard
+	next   uint32 // shard of the next FSM without ID
+	wg     sync.WaitGroup
+
+	*metrics
+}
+
+// engineShard is the run queue of FSM with messages in mailbox.
+type engineShard struct {
+	mu      sync.Mutex
+	cond    *sync.Cond
+	queue   []engineFSM
+	stopped bool
+}
+
+// engineFSM is FSMOf of any types run by the engine.
+type engineFSM interface {
+	// run processes messages of the mailbox (no more than engineBatch) and
+	// reports whether FSM should be returned to the run queue.
+	run() bool
+}
+
+// NewFSM returns new finite state machine with initial state run by the engine.
+// FSM are distributed by shards in turn.
+func (g *Engine) NewFSM(wf Stack, initState string) *FSM {
+	return NewEngineFSMOf(g, wf, initState)
+}
+
+// NewEngineFSMOf returns new finite state machine with states of type S and
+// the payload of type P run by the engine (see Engine.NewFSM).
+func NewEngineFSMOf[S comparable, P any](g *Engine, wf StackOf[S, P], initState S) *FSMOf[S, P] {
+	e := newFSM(wf, initState, g.metrics)
+	e.engine = g
+	e.shard = g.shards[(atomic.AddUint32(&g.next, 1)-1)%uint32(len(g.shards))]
+	return e
+}
+
+// NewFSMWithStore returns new finite state machine run by the engine with the
+// state loaded from the store (see NewFSMWithStore). FSM with the same ID is
+// always run by the same shard.
+func (g *Engine) NewFSMWithStore(ctx context.Context, wf Stack, initState string, store Store, id string) (*FSM, error) {
+	return loadFSM(ctx, wf, initState, store, id, func(wf Stack, state string) *FSM {
+		e := newFSM(wf, state, g.metrics)
+		e.engine = g
+		h := fnv.New32a()
+		h.Write([]byte(id))
+		e.shard = g.shards[h.Sum32()%uint32(len(g.shards))]
+		return e
+	})
+}
+
+// Workers returns number of workers.
+func (g *Engine) Workers() int {
+	return len(g.shards)
+}
+
+// Stop stops workers. It waits until the queued messages are processed.
+// Dispatch of FSM of the engine after Stop fails with ErrEngineStopped.
+func (g *Engine) Stop() {
+	for _, s := range g.shards {
+		s.mu.Lock()
+		s.stopped = true
+		s.cond.Broadcast()
+		s.mu.Unlock()
+	}
+	g.wg.Wait()
+}
+
+// schedule adds the message to the mailbox of FSM and schedules FSM if it is
+// not scheduled yet.
+func (e *FSMOf[S, P]) schedule(m *message[S, P]) {
+	e.mailboxMu.Lock()
+	if e.stopped {
+		e.mailboxMu.Unlock()
+		e.reject(m, m.fail(e.State(), ErrFSMStopped))
+		return
+	}
+	e.mailbox = append(e.mailbox, m)
+	if e.scheduled {
+		e.mailboxMu.Unlock()
+		return
+	}
+	e.scheduled = true
+	e.mailboxMu.Unlock()
+	if e.shard.push(e, false) {
+		return
+	}
+
+	// the shard is stopped, fail all messages of the mailbox
+	e.mailboxMu.Lock()
+	mailbox := e.mailbox
+	e.mailbox = nil
+	e.unschedule()
+	e.mailboxMu.Unlock()
+	for _, m := range mailbox {
+		e.reject(m, m.fail(e.State(), ErrEngineStopped))
+	}
+}
+
+// stopEngine rejects new messages of FSM run by the engine and waits until
+// the queued messages are processed.
+func (e *FSMOf[S, P]) stopEngine() {
+	e.mailboxMu.Lock()
+	e.stopped = true
+	if !e.scheduled {
+		e.mailboxMu.Unlock()
+		return
+	}
+	if e.drained == nil {
+		e.drained = make(chan struct{})
+	}
+	drained := e.drained
+	e.mailboxMu.Unlock()
+	<-drained
+}
+
+func (g *Engine) runWorker(s *engineShard) {
+	defer g.wg.Done()
+
+	for {
+		e := s.pop()
+		if e == nil {
+			return
+		}
+		if e.run() {
+			s.push(e, true)
+		}
+	}
+}
+
+func (e *FSMOf[S, P]) run() bool {
+	for i := 0; i < engineBatch; i++ {
+		m := e.nextMessage()
+		if m == nil {
+			break
+		}
+		e.process(m)
+	}
+	return e.reschedule()
+}
+
+// push adds FSM to the run queue. Returns false if the shard is stopped,
+// except FSM rescheduled by the worker that are processed until the queue is
+// empty.
+func (s *engineShard) push(e engineFSM, rescheduled bool) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.stopped && !rescheduled {
+		return false
+	}
+	s.queue = append(s.queue, e)
+	s.cond.Signal()
+	return true
+}
+
+// pop returns the next scheduled FSM. Returns nil if the shard is stopped
+// and the queue is empty.
+func (s *engineShard) pop() engineFSM {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for len(s.queue) == 0 {
+		if s.stopped {
+			return nil
+		}
+		s.cond.Wait()
+	}
+	e := s.queue[0]
+	s.queue[0] = nil
+	s.queue = s.queue[1:]
+	return e
+}
+
+// nextMessage returns the first message of the mailbox or nil.
+func (e *FSMOf[S, P]) nextMessage() *message[S, P] {
+	e.mailboxMu.Lock()
+	defer e.mailboxMu.Unlock()
+	if len(e.mailbox) == 0 {
+		return nil
+	}
+	m := e.mailbox[0]
+	e.mailbox[0] = nil
+	e.mailbox = e.mailbox[1:]
+	if len(e.mailbox) == 0 {
+		e.mailbox = nil // release memory of the idle FSM
+	}
+	return m
+}
+
+// reject completes the message with the error without dispatch.
+func (e *FSMOf[S, P]) reject(m *message[S, P], err error) {
+	atomic.AddUint64(&e.numProcessed, 1)
+	if m.result != nil {
+		now := time.Now()
+		e.beginResult(m, now)
+		m.endResult(now, err)
+		m.results <- *m.result
+	}
+	m.done <- err
+}
+
+// reschedule reports whether FSM has messages and should be returned to the
+// run queue, otherwise FSM is unscheduled.
+func (e *FSMOf[S, P]) reschedule() bool {
+	e.mailboxMu.Lock()
+	defer e.mailboxMu.Unlock()
+	if len(e.mailbox) > 0 {
+		return true
+	}
+	e.unschedule()
+	return false
+}
+
+// unschedule marks FSM as not scheduled and wakes up Stop (mailboxMu must be
+// held).
+func (e *FSMOf[S, P]) unschedule() {
+	e.scheduled = false
+	if e.drained != nil {
+		close(e.drained)
+		e.drained = nil
+	}
+}
+
+// Describe metrics of all FSM of the engine.
+func (g *Engine) Describe(ch chan<- *prometheus.Desc) {
+	g.metrics.Describe(ch)
+}
+
+// Collect metrics of all FSM of the engine.
+func (g *Engine) Collect(ch chan<- prometheus.Metric) {
+	g.metrics.Collect(ch)
+}
+
+var _ prometheus.Collector = (*Engine)(nil)
@@ -0,0 +1,248 @@
+package ffsm
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_Engine_OrderPerFSM(t *testing.T) {
+	const machines, messages = 50, 100
+
+	engine := NewEngine(4)
+	defer engine.Stop()
+
+	var mu sync.Mutex
+	got := map[*FSM][]int{}
+	var list []*FSM
+	for i := 0; i < machines; i++ {
+		var fsm *FSM
+		wf := make(Stack).Add(AnyState, AnyState, func(ctx context.Context) (context.Context, error) {
+			n, err := strconv.Atoi(GetDstState(ctx))
+			mu.Lock()
+			got[fsm] = append(got[fsm], n)
+			mu.Unlock()
+			return ctx, err
+		})
+		fsm = engine.NewFSM(wf, "-1")
+		list = append(list, fsm)
+	}
+
+	var dones []chan error
+	for n := 0; n < messages; n++ {
+		for _, fsm := range list {
+			done, _ := fsm.AsyncDispatch(context.Background(), strconv.Itoa(n))
+			dones = append(dones, done)
+		}
+	}
+	for _, done := range dones {
+		require.NoError(t, <-done)
+	}
+
+	for _, fsm := range list {
+		assert.EqualValues(t, 0, fsm.Size())
+		assert.Equal(t, strconv.Itoa(messages-1), fsm.State())
+		require.Len(t, got[fsm], messages)
+		for n := range got[fsm] {
+			assert.Equal(t, n, got[fsm][n])
+		}
+	}
+}
+
+func Test_Engine_Dispatch(t *testing.T) {
+	engine := NewEngine(0)
+	assert.True(t, engine.Workers() > 0)
+
+	wf := make(Stack).
+		AddEvent(CloseDoor, "open", OpenDoor).
+		AddEvent(OpenDoor, "close", CloseDoor).
+		AddGuard(CloseDoor, OpenDoor, func(ctx context.Context) error {
+			return fmt.Errorf("locked")
+		})
+	fsm := engine.NewFSM(wf, CloseDoor)
+
+	assert.NoError(t, fsm.DispatchEvent(context.Background(), "open"))
+	assert.Equal(t, OpenDoor, fsm.State())
+	assert.True(t, errors.Is(fsm.Dispatch(context.Background(), TokTokDoor), ErrNotRegTransition))
+	assert.NoError(t, fsm.DispatchEvent(context.Background(), "close"))
+	assert.True(t, errors.Is(fsm.Dispatch(context.Background(), OpenDoor), ErrGuardRejected))
+	assert.Equal(t, CloseDoor, fsm.State())
+
+	// Stop of FSM waits queued messages
+	var dones []chan error
+	for i := 0; i < 10; i++ {
+		done, _ := fsm.AsyncDispatchEvent(context.Background(), "open")
+		dones = append(dones, done)
+		done, _ = fsm.AsyncDispatchEvent(context.Background(), "close")
+		dones = append(dones, done)
+	}
+	fsm.Stop()
+	for _, done := range dones {
+		select {
+		case err := <-done:
+			assert.NoError(t, err)
+		default:
+			t.Fatal("message is not processed after Stop")
+		}
+	}
+
+	// dispatch after Stop of FSM fails without blocking
+	err := fsm.DispatchEvent(context.Background(), "open")
+	assert.True(t, errors.Is(err, ErrFSMStopped))
+	assert.Equal(t, CloseDoor, fsm.State())
+	fsm.Stop()
+
+	other := engine.NewFSM(wf, CloseDoor)
+	engine.Stop()
+
+	// dispatch after Stop of the engine fails without blocking
+	err = other.DispatchEvent(context.Background(), "open")
+	assert.True(t, errors.Is(err, ErrEngineStopped))
+	var dispatchErr DispatchError
+	require.True(t, errors.As(err, &dispatchErr))
+	assert.Equal(t, CloseDoor, dispatchErr.SrcState)
+	res, err := other.DispatchResult(context.Background(), OpenDoor)
+	assert.True(t, errors.Is(err, ErrEngineStopped))
+	assert.Equal(t, OpenDoor, res.DstState)
+	assert.EqualValues(t, 0, other.Size())
+	other.Stop()
+	assert.Equal(t, CloseDoor, other.State())
+}
+
+func Test_Engine_StopConcurrentDispatch(t *testing.T) {
+	engine := NewEngine(2)
+	defer engine.Stop()
+	fsm := engine.NewFSM(make(Stack).Add(AnyState, AnyState), "0")
+
+	wg := sync.WaitGroup{}
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for n := 1; n <= 50; n++ {
+				err := fsm.Dispatch(context.Background(), fmt.Sprint(n))
+				if errors.Is(err, ErrFSMStopped) {
+					return
+				}
+				if err != nil {
+					t.Error(err)
+				}
+			}
+		}()
+	}
+	time.Sleep(time.Millisecond)
+	fsm.Stop()
+	wg.Wait()
+	fsm.Stop()
+	assert.EqualValues(t, 0, fsm.Size())
+}
+
+func Test_Engine_Manager(t *testing.T) {
+	engine := NewEngine(2)
+	defer engine.Stop()
+
+	store := NewMemoryStore()
+	m := NewManager(make(Stack).Add(CloseDoor, OpenDoor).Add(OpenDoor, CloseDoor), CloseDoor, store)
+	m.SetEngine(engine)
+	defer m.Stop()
+
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func(id string) {
+			defer wg.Done()
+			assert.NoError(t, m.Dispatch(context.Background(), id, OpenDoor))
+			assert.NoError(t, m.Dispatch(context.Background(), id, CloseDoor))
+			assert.NoError(t, m.Dispatch(context.Background(), id, OpenDoor))
+		}("door" + strconv.Itoa(i))
+	}
+	wg.Wait()
+
+	for i := 0; i < 10; i++ {
+		record, err := store.Load(context.Background(), "door"+strconv.Itoa(i))
+		require.NoError(t, err)
+		assert.Equal(t, OpenDoor, record.State)
+		assert.EqualValues(t, 3, record.Version)
+	}
+}
+
+// benchmarkMachines is the number of FSM in benchmarks of the engine.
+const benchmarkMachines = 1000
+
+func benchmarkDispatch(b *testing.B, newFSM func(wf Stack, initState string) *FSM) {
+	wf := make(Stack).
+		Add(CloseDoor, OpenDoor, nil).
+		Add(OpenDoor, CloseDoor, nil)
+
+	list := make([]*FSM, benchmarkMachines)
+	for i := range list {
+		list[i] = newFSM(wf, CloseDoor)
+	}
+	ctx := context.Background()
+	b.ReportAllocs()
+	b.ResetTimer()
+
+	dones := make([]chan error, len(list))
+	next := OpenDoor
+	for n := 0; n < b.N; n += len(list) {
+		for i, fsm := range list {
+			dones[i], _ = fsm.AsyncDispatch(ctx, next)
+		}
+		for _, done := range dones {
+			if err := <-done; err != nil {
+				b.Fatal("dispatch with err", err)
+			}
+		}
+		if next == OpenDoor {
+			next = CloseDoor
+		} else {
+			next = OpenDoor
+		}
+	}
+
+	b.StopTimer()
+	for _, fsm := range list {
+		fsm.Stop()
+	}
+}
+
+func Benchmark_Dispatch_GoroutinePerFSM(b *testing.B) {
+	benchmarkDispatch(b, NewFSM)
+}
+
+func Benchmark_Dispatch_Engine(b *testing.B) {
+	engine := NewEngine(0)
+	defer engine.Stop()
+	benchmarkDispatch(b, engine.NewFSM)
+}
+
+func benchmarkIdle(b *testing.B, newFSM func(wf Stack, initState string) *FSM) {
+	wf := make(Stack).Add(CloseDoor, OpenDoor, nil)
+	list := make([]*FSM, 0, b.N)
+	b.ReportAllocs()
+	b.ResetTimer()
+	for n := 0; n < b.N; n++ {
+		list = append(list, newFSM(wf, CloseDoor))
+	}
+	b.StopTimer()
+	for _, fsm := range list {
+		fsm.Stop()
+	}
+}
+
+func Benchmark_IdleFSM_GoroutinePerFSM(b *testing.B) {
+	benchmarkIdle(b, NewFSM)
+}
+
+func Benchmark_IdleFSM_Engine(b *testing.B) {
+	engine := NewEngine(0)
+	defer engine.Stop()
+	benchmarkIdle(b, engine.NewFSM)
+}
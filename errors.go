@@ -1,33 +1,212 @@
 package ffsm
 
-import "errors"
+import (
+	"context"
+	"errors"
+	"fmt"
+)
 
 var (
 	// ErrNotInitalState is the error returned by Machine when the is
 	// not have initial state of Machine.
 	ErrNotInitalState = errors.New("Is not set initial value of state")
 
-	// ErrCtxCanceled is the error when context is canceled.
+	// ErrCtxCanceled is the error when context is canceled. Use errors.Is to
+	// check DispatchError of the canceled dispatch.
 	ErrCtxCanceled = errors.New("Context canceled")
 
 	// ErrNotRegTransition is the error returned by Machine from Dispatch method when the is
 	// have not rules for current transition (src->dst not have actions).
 	ErrNotRegTransition = errors.New("Not registred transition")
+
+	// ErrTimeout is the error of the handler of transition that exceeded
+	// timeout of the action or deadline of the transition. Use errors.Is to check it.
+	ErrTimeout = errors.New("Timeout of handler")
+
+	// ErrStateNotFound is the error returned by Store from Load method when
+	// the is have not state of FSM.
+	ErrStateNotFound = errors.New("State not found")
+
+	// ErrStateConflict is the error returned by Store from Save method when
+	// the state was changed since it was loaded. Use errors.Is to check it.
+	ErrStateConflict = errors.New("State conflict")
+
+	// ErrBrokenJournal is the error returned by Replay when records of journal
+	// are not consistent (gaps of sequence numbers or wrong source states).
+	ErrBrokenJournal = errors.New("Broken journal")
+
+	// ErrStateName is the error returned by ParseStateName when the name of
+	// the state of the store or the journal can not be parsed to the state of
+	// FSMOf. Use errors.Is to check it.
+	ErrStateName = errors.New("Unknown name of state")
+
+	// ErrNotRegRegion is the error returned by Machine from DispatchRegion
+	// method when the is have not region with the name.
+	ErrNotRegRegion = errors.New("Not registred region")
+
+	// ErrGuardRejected is the error returned by Machine from Dispatch method when
+	// one of the guards of transition refused it. Use errors.Is to check it.
+	ErrGuardRejected = errors.New("Guard rejected transition")
+
+	// ErrInvalidStack is the error of ValidationReport.Err when Stack.Validate
+	// found problems. Use errors.Is to check it.
+	ErrInvalidStack = errors.New("Invalid stack")
+
+	// ErrInvalidDefinition is the error returned by ParseDefinition and
+	// Definition.Build when the definition is wrong. Use errors.Is to check it.
+	ErrInvalidDefinition = errors.New("Invalid definition")
+
+	// ErrEngineStopped is the error returned by Machine run by the Engine
+	// from Dispatch method when the engine is stopped. Use errors.Is to check it.
+	ErrEngineStopped = errors.New("Engine stopped")
+
+	// ErrFSMStopped is the error returned by Machine run by the Engine from
+	// Dispatch method after Stop of the Machine. Use errors.Is to check it.
+	ErrFSMStopped = errors.New("FSM stopped")
+
+	// ErrManagerStopped is the error returned by Manager from Dispatch method
+	// when the manager is stopped. Use errors.Is to check it.
+	ErrManagerStopped = errors.New("Manager stopped")
+
+	// ErrUnsupported is the error of the construct of SCXML document that can
+	// not be represented by Stack (and back). Use errors.Is to check it.
+	ErrUnsupported = errors.New("Unsupported construct")
 )
 
+// GuardError is the error of the guard that refused the transition.
+type GuardError struct {
+	SrcState   string
+	DstState   string
+	Event      string
+	IndexGuard int
+	Err        error
+}
+
+func (e GuardError) Error() string {
+	if e.Event != "" {
+		return fmt.Sprintf("guard rejected transition %q=>%q by %q #%d: %v", e.SrcState, e.DstState, e.Event, e.IndexGuard, e.Err)
+	}
+	return fmt.Sprintf("guard rejected transition %q=>%q #%d: %v", e.SrcState, e.DstState, e.IndexGuard, e.Err)
+}
+
+// Unwrap returns the error of the guard.
+func (e GuardError) Unwrap() error {
+	return e.Err
+}
+
+// Is reports whether target is ErrGuardRejected.
+func (e GuardError) Is(target error) bool {
+	return target == ErrGuardRejected
+}
+
+// ConflictError is the error of the stale write of the state to Store: the
+// state of FSM was changed (by another instance) since the version was loaded.
+type ConflictError struct {
+	ID      string
+	Version uint64 // version of the loaded state (zero if it was not exists)
+}
+
+func (e ConflictError) Error() string {
+	return fmt.Sprintf("state of %q was changed since version %d", e.ID, e.Version)
+}
+
+// Is reports whether target is ErrStateConflict.
+func (e ConflictError) Is(target error) bool {
+	return target == ErrStateConflict
+}
+
 // DispatchError is the container with custom errors for dispatcher.
+// It is returned for each failure of the dispatch: the handler of transition
+// (guard, action or hook) returns the error or panics, the transition is not
+// registered, the context is done, the state is not saved and etc. Use
+// errors.Is and errors.As for the error of the handler and sentinel errors
+// (ErrNotRegTransition, ErrGuardRejected, ErrCtxCanceled and etc). States are
+// names of states (see StateName).
+//
+// Error has the same format for Dispatch and DispatchEvent: the error with the
+// transition, for example: abort ("open"=>"close" by "close" #1).
 type DispatchError struct {
+	// ActionName the name of the failed action (see Action.Name and Named),
+	// the description of the action if it is not named, JournalActionName or
+	// StoreActionName. Empty if the dispatch failed not by the action.
 	ActionName        string
+	Region            string
 	SrcState          string
 	DstState          string
+	Event             string
+	Kind              KeyKind
+	Index             int  // index of the action in list of the entry of Stack (-1 if failed not by the action)
+	Guard             bool // the failed action is the guard (Err is GuardError)
 	Err               error
 	IsPanic           bool
 	PanicStackRuntime string
+
+	// Attempts number of attempts to execute the failed action (see RetryPolicy).
+	Attempts int
+
+	// Compensations results of compensations of executed actions in order
+	// of execution (reverse order of actions).
+	Compensations []CompensationResult
+
+	// Committed names of regions which transitions by the event were
+	// committed before the failed region (see FSM.AddRegion).
+	Committed []string
 }
 
 func (e DispatchError) Error() string {
 	if e.Err == nil {
 		return ""
 	}
-	return e.Err.Error()
+	var msg string
+	switch {
+	case e.IsPanic:
+		msg = fmt.Sprintf("dispatcher panic: %v (%s)\n%s", e.Err, e.transition(), e.PanicStackRuntime)
+	case e.Guard:
+		msg = e.Err.Error() // GuardError has the transition
+	default:
+		msg = fmt.Sprintf("%v (%s)", e.Err, e.transition())
+	}
+	for _, c := range e.Compensations {
+		if c.Err != nil {
+			msg += fmt.Sprintf("; compensation %s failed: %v", c.ActionName, c.Err)
+		}
+	}
+	return msg
+}
+
+// Unwrap returns the error of the handler.
+func (e DispatchError) Unwrap() error {
+	return e.Err
+}
+
+// Is reports whether target is ErrCtxCanceled and the dispatch failed by
+// the canceled context.
+func (e DispatchError) Is(target error) bool {
+	return target == ErrCtxCanceled && errors.Is(e.Err, context.Canceled)
+}
+
+func (e DispatchError) transition() string {
+	if e.Index >= 0 {
+		return fmt.Sprintf("%q=>%q%s", e.SrcState, e.DstState, stepSuffix(e.Event, e.Kind, e.Index))
+	}
+	// failed not by the action, the destination state of the event can be
+	// not resolved
+	s := fmt.Sprintf("%q", e.SrcState)
+	if e.DstState != "" {
+		s += fmt.Sprintf("=>%q", e.DstState)
+	}
+	if e.Event != "" {
+		s += fmt.Sprintf(" by %q", e.Event)
+	}
+	return s
+}
+
+// CompensationResult is the result of compensation of the action.
+type CompensationResult struct {
+	ActionName        string
+	Kind              KeyKind
+	Index             int
+	Err               error
+	IsPanic           bool
+	PanicStackRuntime string
 }
@@ -20,11 +20,44 @@ var DefaultToDispatchCap = 8
 type Dispatcher func(ctx context.Context, next string) (chan error, context.CancelFunc)
 
 // NewFSM returns new finite state machine with initial state.
+// If the initial state has initial child state then FSM starts in it.
 func NewFSM(wf Stack, initState string) *FSM {
-	e := &FSM{
-		wf:         wf,
-		toDispatch: make(chan *messageToDispatch, DefaultToDispatchCap),
-		state:      initState,
+	return NewFSMOf(wf, initState)
+}
+
+// NewFSMOf returns new finite state machine with states of type S and the
+// payload of type P with initial state (see NewFSM).
+func NewFSMOf[S comparable, P any](wf StackOf[S, P], initState S) *FSMOf[S, P] {
+	e := newFSM(wf, initState, newMetrics())
+	e.toDispatch = make(chan *message[S, P], DefaultToDispatchCap)
+	e.wg.Add(1)
+	go e.runDispatcher()
+	return e
+}
+
+// newFSM returns FSM without dispatcher.
+func newFSM[S comparable, P any](wf StackOf[S, P], initState S, m *metrics) *FSMOf[S, P] {
+	initState = wf.initial(initState)
+	return &FSMOf[S, P]{
+		regions: []*region[S, P]{
+			{name: MainRegion, wf: wf, state: initState},
+		},
+		initState: initState,
+		metrics:   m,
+	}
+}
+
+// metrics of FSM. The metrics are shared by all FSM of the Engine.
+type metrics struct {
+	mActionDuration *prometheus.HistogramVec
+	mTotalDuration  *prometheus.HistogramVec
+	mActionRequest  *prometheus.CounterVec
+	mActionAttempt  *prometheus.CounterVec
+	mTotalRequest   *prometheus.CounterVec
+}
+
+func newMetrics() *metrics {
+	return &metrics{
 		mActionDuration: prometheus.NewHistogramVec(
 			prometheus.HistogramOpts{
 				Name:      "ffsm_action_duration_ms",
@@ -61,6 +94,14 @@ func NewFSM(wf Stack, initState string) *FSM {
 			},
 			[]string{"ffsm"},
 		),
+		mActionAttempt: prometheus.NewCounterVec(
+			prometheus.CounterOpts{
+				Name:      "ffsm_exec_action_attempt_total",
+				Help:      "Number of attempts to execute actions (including retries).",
+				Subsystem: "ffsm",
+			},
+			[]string{"ffsm"},
+		),
 		mTotalRequest: prometheus.NewCounterVec(
 			prometheus.CounterOpts{
 				Name:      "ffsm_dispatch_total",
@@ -70,224 +111,669 @@ func NewFSM(wf Stack, initState string) *FSM {
 			[]string{"ffsm"},
 		),
 	}
-	e.wg.Add(1)
-	go e.runDispatcher()
-	return e
 }
 
-// FSM finite state machine.
-type FSM struct {
-	wf         Stack
-	state      string
+// FSMOf finite state machine with states of type S and the payload of
+// type P (see StackOf). The zero value of S is UnknownState.
+//
+// Errors, metrics, journals and stores have names of states (see StateName).
+type FSMOf[S comparable, P any] struct {
+	regions    []*region[S, P] // the first is MainRegion
+	initState  S               // initial state of MainRegion (before load from the store)
 	stateMutex sync.RWMutex
 	wg         sync.WaitGroup
-	toDispatch chan *messageToDispatch
+	toDispatch chan *message[S, P]
 
 	numAdded     uint64 // counter of added commands
 	numProcessed uint64 // counter of processed commands
 
+	transitionTimeout int64 // time.Duration
+
+	interceptors []Interceptor
+
+	store  Store
+	id     string
+	record Record // last loaded or saved record of the store
+
+	journal          Journal
+	seq              uint64       // sequence number of the last record of journal
+	snapshotInterval uint64       // number of records between snapshots
+	restored         map[string]S // states of regions restored from the journal
+
 	name string
 
-	mActionDuration *prometheus.HistogramVec
-	mTotalDuration  *prometheus.HistogramVec
-	mActionRequest  *prometheus.CounterVec
-	mTotalRequest   *prometheus.CounterVec
+	engine    *Engine
+	shard     *engineShard
+	mailbox   []*message[S, P] // queue of messages if the FSM is run by the engine
+	scheduled bool             // the FSM is in the run queue or is processed by the worker
+	stopped   bool             // the FSM run by the engine is stopped, new messages are rejected
+	drained   chan struct{}    // closed when the stopped FSM is unscheduled
+	mailboxMu sync.Mutex
+
+	*metrics
 }
 
+// FSM finite state machine with states named by strings.
+type FSM = FSMOf[string, interface{}]
+
 // State returns current state.
-func (e *FSM) State() string {
+func (e *FSMOf[S, P]) State() S {
 	e.stateMutex.RLock()
 	defer e.stateMutex.RUnlock()
-	return e.state
+	return e.regions[0].state
+}
+
+// Path returns path of the current state from top-level state to the
+// current state (see StatePath).
+func (e *FSMOf[S, P]) Path() []S {
+	return statePath(e.State())
+}
+
+// In reports whether the state is active: it is the current state or
+// one of its parent states.
+func (e *FSMOf[S, P]) In(state S) bool {
+	current := e.State()
+	return current == state || isDescendant(current, state)
+}
+
+// SetTransitionTimeout sets timeout of each transition (zero is without timeout).
+// If the timeout is exceeded the context of executing action is canceled and
+// the transition fails with ErrTimeout. The timeout of the transition set by
+// Stack.SetTimeout is used instead of it.
+func (e *FSMOf[S, P]) SetTransitionTimeout(timeout time.Duration) {
+	atomic.StoreInt64(&e.transitionTimeout, int64(timeout))
+}
+
+// TransitionTimeout returns timeout of each transition.
+func (e *FSMOf[S, P]) TransitionTimeout() time.Duration {
+	return time.Duration(atomic.LoadInt64(&e.transitionTimeout))
 }
 
 // SetName sets name of FSM (for prometheus labels).
-func (e *FSM) SetName(name string) {
+func (e *FSMOf[S, P]) SetName(name string) {
 	e.name = name
 }
 
 // SetState sets new state.
-func (e *FSM) SetState(newState string) {
-	e.stateMutex.Lock()
-	e.state = newState
-	e.stateMutex.Unlock()
+func (e *FSMOf[S, P]) SetState(newState S) {
+	e.setRegionState(e.regions[0], newState)
 }
 
-type dispatcherError struct {
-	Err         error
-	Recover     interface{}
-	DebugStack  string
-	SrcState    string
-	DstState    string
-	IndexAction int
-}
-
-func (e dispatcherError) Error() string {
-	if e.Err != nil {
-		return e.Err.Error()
+// stepSuffix returns description of the step of transition for errors and metrics.
+func stepSuffix(event string, kind KeyKind, index int) string {
+	var s string
+	if event != "" {
+		s += fmt.Sprintf(" by %q", event)
 	}
-	if e.Recover == nil {
-		return ""
+	if kind != TransitionKind {
+		s += " on " + kind.String()
 	}
-	return fmt.Sprintf("dispatcher panic: %v (%q=>%q #%d)\n%s", e.Recover, e.SrcState, e.DstState, e.IndexAction, e.DebugStack)
+	return s + fmt.Sprintf(" #%d", index)
 }
 
-type resultOfActionTransition struct {
-	ctx context.Context
-	err error
+type resultOfActionTransition[P any] struct {
+	ctx     context.Context
+	payload P
+	err     error
 }
 
-func (e *FSM) runDispatcher() {
+func (e *FSMOf[S, P]) runDispatcher() {
 	defer e.wg.Done()
 
-	var current string
-	var actions []Procedure
-	var err error
-	var dispatchStart, actionStart time.Time
-	var actionRes chan resultOfActionTransition
-
 	for m := range e.toDispatch {
-		atomic.AddUint64(&e.numProcessed, 1)
-		dispatchStart = time.Now()
-		err = nil
-		current = e.State()
-
-		if current == UnknownState {
-			if m.done != nil {
-				m.done <- ErrNotInitalState
-				continue
-			}
+		e.process(m)
+	} // forend dispatch
+}
+
+// process dispatches the message and sends the result to it.
+func (e *FSMOf[S, P]) process(m *message[S, P]) {
+	atomic.AddUint64(&e.numProcessed, 1)
+	dispatchStart := time.Now()
+
+	if m.result == nil {
+		m.done <- e.dispatch(m)
+	} else {
+		e.beginResult(m, dispatchStart)
+		err := e.dispatch(m)
+		m.endResult(dispatchStart, err)
+		m.results <- *m.result
+		m.done <- err
+	}
+
+	// the check of CanDispatch is not the dispatch
+	if m.dryRun {
+		return
+	}
+	e.mTotalDuration.WithLabelValues(e.name).Observe(float64(time.Since(dispatchStart).Nanoseconds() / int64(time.Millisecond)))
+	e.mTotalRequest.WithLabelValues(e.name).Inc()
+}
+
+// dispatch executes transition for the message and returns the result of it.
+// The event is dispatched to all regions that accept it.
+func (e *FSMOf[S, P]) dispatch(m *message[S, P]) error {
+	var unknown S
+	if e.State() == unknown {
+		return m.fail(unknown, ErrNotInitalState)
+	}
+
+	if m.event == "" {
+		r := e.region(m.region)
+		if r == nil {
+			return m.fail(unknown, fmt.Errorf("%w: %q", ErrNotRegRegion, m.region))
+		}
+		current := e.regionState(r)
+		key, ok := r.wf.Resolve(current, m.next, "")
+		if !ok {
+			return m.fail(current, ErrNotRegTransition)
 		}
+		return e.transit(m, r, key, m.next)
+	}
 
-		actions = e.wf.Get(current, m.next)
-		if actions == nil {
-			m.done <- ErrNotRegTransition
+	accepted := false
+	var committed []string // regions changed before the failure
+	for _, r := range e.regionList() {
+		current := e.regionState(r)
+		key, ok := r.wf.Resolve(current, unknown, m.event)
+		if current == unknown || !ok {
 			continue
 		}
+		accepted = true
+		if err := e.transit(m, r, key, key.Dst); err != nil {
+			if dispatchErr, ok := err.(DispatchError); ok && len(committed) > 0 {
+				dispatchErr.Committed = committed
+				return dispatchErr
+			}
+			return err
+		}
+		if !m.dryRun {
+			committed = append(committed, r.name)
+		}
+	}
+	if !accepted {
+		return m.fail(e.State(), fmt.Errorf("%w: event %q from %q", ErrNotRegTransition, m.event, StateName(e.State())))
+	}
+	return nil
+}
 
-		if m.ctx.Err() != nil {
-			m.done <- m.ctx.Err()
-			continue
+// transit executes transition of the region registered by key to target state.
+func (e *FSMOf[S, P]) transit(m *message[S, P], r *region[S, P], key StackKeyOf[S], target S) error {
+	current := e.regionState(r)
+	next := r.wf.initial(target)
+	steps := r.wf.plan(key, current, target, next)
+
+	t := transition{region: r.name, src: StateName(current), dst: StateName(next), event: m.event, dryRun: m.dryRun}
+	if m.ctx.Err() != nil {
+		return DispatchError{
+			Region:   r.name,
+			SrcState: t.src,
+			DstState: t.dst,
+			Event:    m.event,
+			Index:    -1,
+			Err:      m.ctx.Err(),
 		}
+	}
 
-		nextCtx, cancel := context.WithCancel(hydrateContextForAction(m.ctx, current, m.next))
+	if m.result != nil {
+		m.result.Region, m.result.SrcState, m.result.DstState = r.name, current, next
+	}
+	nextCtx, cancel := context.WithCancel(hydrateContextForAction(m.ctx, current, next, m.event, r.name))
+	defer cancel()
+	timeout := e.TransitionTimeout()
+	if d, ok := r.wf.Timeout(key); ok {
+		timeout = d
+	}
+	if timeout > 0 {
+		t.deadline = time.Now().Add(timeout)
+		nextCtx, cancel = context.WithDeadline(nextCtx, t.deadline)
 		defer cancel()
+	}
 
-		for _i, actionFn := range actions {
-			actionStart = time.Now()
-			actionRes = make(chan resultOfActionTransition, 1)
+	var executed []step[P] // executed actions for compensation
+	payload := m.payload
+	rollback := func(dispatchErr DispatchError) DispatchError {
+		// compensations are executed even if the transition is timed out
+		t.deadline = time.Time{}
+		compensateCtx := valueContext{Context: context.Background(), values: nextCtx}
+		dispatchErr.Compensations = e.compensate(compensateCtx, t, executed, payload)
+		return dispatchErr
+	}
 
-			// For simple FSM, without transition handlers
-			if actionFn == nil {
-				continue
-			}
+	for _, action := range steps {
+		// For simple FSM, without transition handlers
+		if action.empty() {
+			continue
+		}
+		// only guards are executed by CanDispatch
+		if m.dryRun && !action.Guard {
+			continue
+		}
 
-			go func(ctx context.Context) {
-				defer func() {
-					if r := recover(); r != nil {
-						actionRes <- resultOfActionTransition{
-							err: dispatcherError{
-								Recover:     r,
-								SrcState:    current,
-								DstState:    m.next,
-								IndexAction: _i,
-								DebugStack:  string(debug.Stack()),
-							},
-						}
-						return
+		info := action.info(t, false)
+		actionStart := time.Now()
+		ctx, out, attempts, err := e.execute(nextCtx, info, t, action, action.handler(), payload, action.Retry)
+		if m.result != nil {
+			m.result.Actions = append(m.result.Actions, ActionResult{
+				ActionInfo: info,
+				Duration:   time.Since(actionStart),
+				Attempts:   attempts,
+				Err:        err,
+			})
+		}
+		if err != nil {
+			// exit transition, because there was an error on one
+			// of the handlers of transition
+			dispatchErr := action.error(t, err)
+			dispatchErr.Attempts = attempts
+			if action.Guard {
+				if _, isPanic := err.(panicError); !isPanic {
+					dispatchErr.Guard = true
+					dispatchErr.Err = GuardError{
+						Err:        err,
+						SrcState:   t.src,
+						DstState:   t.dst,
+						Event:      m.event,
+						IndexGuard: action.index,
 					}
-				}()
-
-				ctx, err := actionFn(nextCtx)
-				actionRes <- resultOfActionTransition{
-					err: err,
-					ctx: ctx,
+					return dispatchErr
 				}
-			}(nextCtx)
+			}
+			return rollback(dispatchErr)
+		}
+		nextCtx = ctx
+		if !action.Guard {
+			payload = out
+		}
+		executed = append(executed, action)
+
+		// forend actions
+	}
+
+	if m.dryRun {
+		return nil
+	}
 
-			// waiting done action
-			select {
-			case done := <-actionRes:
-				nextCtx = done.ctx
-				err = done.err
+	if r.name == MainRegion {
+		if err := e.save(nextCtx, next); err != nil {
+			return rollback(DispatchError{
+				ActionName: StoreActionName,
+				Region:     r.name,
+				SrcState:   t.src,
+				DstState:   t.dst,
+				Event:      m.event,
+				Index:      -1,
+				Err:        err,
+			})
+		}
+	}
+
+	// the record is appended after the save, so the journal has not records
+	// of transitions failed by the store
+	if err := e.appendJournal(nextCtx, t); err != nil {
+		return rollback(DispatchError{
+			ActionName: JournalActionName,
+			Region:     r.name,
+			SrcState:   t.src,
+			DstState:   t.dst,
+			Event:      m.event,
+			Index:      -1,
+			Err:        err,
+		})
+	}
+
+	e.setRegionState(r, next)
+	e.snapshot(nextCtx)
+	m.final = nextCtx
+	m.payload = payload
+	return nil
+}
+
+// execute executes procedure of the step of transition with the payload
+// through interceptors with retries by the policy (nil is without retries).
+// Returns the payload of the procedure and number of attempts.
+func (e *FSMOf[S, P]) execute(ctx context.Context, info ActionInfo, t transition, action step[P], fn handler[P], payload P, retry *RetryPolicy) (res context.Context, out P, attempts int, err error) {
+	out = payload
+	final := func(ctx context.Context) (context.Context, error) {
+		for {
+			attempts++
+			if !info.DryRun {
+				e.mActionAttempt.WithLabelValues(info.String()).Inc()
+			}
+			res, p, err := e.attempt(ctx, t, action, fn, payload)
+			if err == nil {
+				out = p
+			}
+			if err == nil || !retry.retry(ctx, attempts, err) {
+				return res, err
+			}
+		}
+	}
+
+	defer func() {
+		// panic in interceptor
+		if r := recover(); r != nil {
+			err = panicError{
+				recovered:  r,
+				debugStack: string(debug.Stack()),
 			}
+		}
+	}()
+	res, err = e.intercept(info, final)(ctx)
+	return res, out, attempts, err
+}
 
-			actName := fmt.Sprintf("%q -> %q #%d", current, m.next, _i)
-			e.mActionDuration.WithLabelValues(actName).Observe(float64(time.Since(actionStart).Nanoseconds() / int64(time.Millisecond)))
-			e.mActionRequest.WithLabelValues(actName).Inc()
+// attempt executes procedure of the step of transition once. The procedure is
+// interrupted by timeout of the action or deadline of the transition.
+func (e *FSMOf[S, P]) attempt(ctx context.Context, t transition, action step[P], fn handler[P], payload P) (context.Context, P, error) {
+	actionRes := make(chan resultOfActionTransition[P], 1)
 
-			if err != nil {
-				// exit transition, because there was an error on one
-				// of the handlers of transition
-				break
+	timeout := action.Timeout
+	var timeoutErr error
+	if timeout > 0 {
+		timeoutErr = fmt.Errorf("%w: action timeout %s", ErrTimeout, timeout)
+	}
+	if !t.deadline.IsZero() {
+		if rest := time.Until(t.deadline); timeout <= 0 || rest < timeout {
+			timeout = rest
+			timeoutErr = fmt.Errorf("%w: transition deadline exceeded", ErrTimeout)
+		}
+	}
+	actionCtx := ctx
+	var timer <-chan time.Time
+	if timeout > 0 || timeoutErr != nil {
+		var cancel context.CancelFunc
+		actionCtx, cancel = context.WithTimeout(ctx, timeout)
+		defer cancel()
+		timeoutTimer := time.NewTimer(timeout)
+		defer timeoutTimer.Stop()
+		timer = timeoutTimer.C
+	}
+
+	go func(ctx context.Context) {
+		defer func() {
+			if r := recover(); r != nil {
+				actionRes <- resultOfActionTransition[P]{
+					err: panicError{
+						recovered:  r,
+						debugStack: string(debug.Stack()),
+					},
+				}
+				return
 			}
+		}()
 
-			// forend actions
+		ctx, payload, err := fn(ctx, payload)
+		actionRes <- resultOfActionTransition[P]{
+			err:     err,
+			ctx:     ctx,
+			payload: payload,
 		}
+	}(actionCtx)
+
+	// waiting done action or timeout, the handler is left running in
+	// background and its result is dropped
+	var done resultOfActionTransition[P]
+	select {
+	case done = <-actionRes:
+		if done.err == nil && done.ctx != nil && actionCtx != ctx {
+			// the context of the next action must not be canceled
+			// with the context of this action
+			done.ctx = valueContext{Context: ctx, values: done.ctx}
+		}
+	case <-timer:
+		done.err = timeoutErr
+	}
+
+	return done.ctx, done.payload, done.err
+}
 
-		if err == nil {
-			e.SetState(m.next)
+// compensate executes compensations of executed actions in reverse order
+// with the payload of the transition.
+func (e *FSMOf[S, P]) compensate(ctx context.Context, t transition, executed []step[P], payload P) []CompensationResult {
+	var results []CompensationResult
+	for i := len(executed) - 1; i >= 0; i-- {
+		action := executed[i]
+		fn := action.compensation()
+		if fn == nil {
+			continue
 		}
-		m.done <- err
+		_, _, _, err := e.execute(ctx, action.info(t, true), t, action, fn, payload, nil)
+		res := CompensationResult{
+			ActionName: action.actionName(t),
+			Kind:       action.kind,
+			Index:      action.index,
+			Err:        err,
+		}
+		if p, ok := err.(panicError); ok {
+			res.IsPanic = true
+			res.PanicStackRuntime = p.debugStack
+		}
+		results = append(results, res)
+	}
+	return results
+}
 
-		e.mActionDuration.WithLabelValues(e.name).Observe(float64(time.Since(dispatchStart).Nanoseconds() / int64(time.Millisecond)))
-		e.mTotalRequest.WithLabelValues(e.name).Inc()
+// transition describes the executed transition for errors and metrics (with
+// names of states).
+type transition struct {
+	region   string
+	src      string
+	dst      string
+	event    string
+	deadline time.Time // zero if the transition has not timeout
+	dryRun   bool      // only guards are executed (see CanDispatch)
+}
 
-	} // forend dispatch
+// actionName returns the name of the action or the description of it if the
+// action is not named.
+func (action step[P]) actionName(t transition) string {
+	if action.Name != "" {
+		return action.Name
+	}
+	return action.info(t, false).String()
+}
+
+func (action step[P]) info(t transition, compensation bool) ActionInfo {
+	return ActionInfo{
+		Name:         action.Name,
+		Region:       t.region,
+		SrcState:     t.src,
+		DstState:     t.dst,
+		Event:        t.event,
+		Kind:         action.kind,
+		Index:        action.index,
+		Guard:        action.Guard,
+		Compensation: compensation,
+		DryRun:       t.dryRun,
+	}
+}
+
+func (action step[P]) error(t transition, err error) DispatchError {
+	dispatchErr := DispatchError{
+		ActionName: action.actionName(t),
+		Region:     t.region,
+		SrcState:   t.src,
+		DstState:   t.dst,
+		Event:      t.event,
+		Kind:       action.kind,
+		Index:      action.index,
+		Err:        err,
+	}
+	if p, ok := err.(panicError); ok {
+		dispatchErr.IsPanic = true
+		dispatchErr.PanicStackRuntime = p.debugStack
+	}
+	return dispatchErr
+}
+
+// panicError is the error of the recovered panic in the handler.
+type panicError struct {
+	recovered  interface{}
+	debugStack string
+}
+
+func (e panicError) Error() string {
+	return fmt.Sprint(e.recovered)
 }
 
 // AsyncDispatch dispatcher of finite state machine (thread-safe).
 // Returns the channel for feedback and the function of cancel of transition context.
-func (e *FSM) AsyncDispatch(ctx context.Context, next string) (chan error, context.CancelFunc) {
-	ctx, cancel := context.WithCancel(ctx)
-	msg := &messageToDispatch{
-		ctx:  ctx,
-		next: next,
-		done: make(chan error, 1),
-	}
-	e.toDispatch <- msg
-	atomic.AddUint64(&e.numAdded, 1)
-	return msg.done, cancel
+func (e *FSMOf[S, P]) AsyncDispatch(ctx context.Context, next S) (chan error, context.CancelFunc) {
+	return e.asyncDispatch(ctx, MainRegion, next, "")
 }
 
 // Dispatch dispatch and wait for completion.
-func (e *FSM) Dispatch(ctx context.Context, next string) error {
+func (e *FSMOf[S, P]) Dispatch(ctx context.Context, next S) error {
 	done, _ := e.AsyncDispatch(ctx, next)
 	return <-done
 }
 
-// Stop stops finite state machine.
-func (e *FSM) Stop() {
+// DispatchPayload dispatch with the payload and wait for completion. The
+// payload is passed to PayloadProcedure of actions of the transition, each
+// of them returns the payload for the next one. Returns the payload of the
+// last PayloadProcedure (the payload as is if there are not them) or zero
+// value if the transition failed.
+func (e *FSMOf[S, P]) DispatchPayload(ctx context.Context, next S, payload P) (P, error) {
+	return e.dispatchPayload(e.newMessage(ctx, MainRegion, next, ""), payload)
+}
+
+// DispatchEventPayload dispatch the event with the payload and wait for
+// completion (see DispatchPayload).
+func (e *FSMOf[S, P]) DispatchEventPayload(ctx context.Context, event string, payload P) (P, error) {
+	var unknown S
+	return e.dispatchPayload(e.newMessage(ctx, MainRegion, unknown, event), payload)
+}
+
+func (e *FSMOf[S, P]) dispatchPayload(m *message[S, P], payload P) (P, error) {
+	m.payload = payload
+	if err := <-e.enqueue(m).done; err != nil {
+		var zero P
+		return zero, err
+	}
+	return m.payload, nil
+}
+
+// AsyncDispatchEvent dispatcher of the event (thread-safe). Destination state
+// is resolved by the pair of current state and event registered by Stack.AddEvent.
+// Returns the channel for feedback and the function of cancel of transition context.
+func (e *FSMOf[S, P]) AsyncDispatchEvent(ctx context.Context, event string) (chan error, context.CancelFunc) {
+	var unknown S
+	return e.asyncDispatch(ctx, MainRegion, unknown, event)
+}
+
+// DispatchEvent dispatch the event and wait for completion.
+func (e *FSMOf[S, P]) DispatchEvent(ctx context.Context, event string) error {
+	done, _ := e.AsyncDispatchEvent(ctx, event)
+	return <-done
+}
+
+func (e *FSMOf[S, P]) asyncDispatch(ctx context.Context, region string, next S, event string) (chan error, context.CancelFunc) {
+	ctx, cancel := context.WithCancel(ctx)
+	msg := e.enqueue(e.newMessage(ctx, region, next, event))
+	return msg.done, cancel
+}
+
+func (e *FSMOf[S, P]) newMessage(ctx context.Context, region string, next S, event string) *message[S, P] {
+	return &message[S, P]{
+		ctx:    ctx,
+		region: region,
+		next:   next,
+		event:  event,
+		done:   make(chan error, 1),
+	}
+}
+
+// fail returns DispatchError of the message failed not by the action.
+func (m *message[S, P]) fail(src S, err error) error {
+	return DispatchError{
+		Region:   m.region,
+		SrcState: StateName(src),
+		DstState: StateName(m.next),
+		Event:    m.event,
+		Index:    -1,
+		Err:      err,
+	}
+}
+
+// enqueue adds the message to the queue of the dispatcher.
+func (e *FSMOf[S, P]) enqueue(msg *message[S, P]) *message[S, P] {
+	msg.enqueued = time.Now()
+	atomic.AddUint64(&e.numAdded, 1)
+	if e.engine != nil {
+		e.schedule(msg)
+	} else {
+		e.toDispatch <- msg
+	}
+	return msg
+}
+
+// Stop stops finite state machine. It waits until the queued messages are
+// processed. Dispatch of FSM run by the engine after Stop fails with
+// ErrFSMStopped.
+func (e *FSMOf[S, P]) Stop() {
+	if e.engine != nil {
+		e.stopEngine()
+		return
+	}
 	close(e.toDispatch)
 	e.wg.Wait()
 }
 
 // Size returns number of messages in the queue (thread-safe).
-func (e *FSM) Size() uint64 {
+func (e *FSMOf[S, P]) Size() uint64 {
 	return atomic.LoadUint64(&e.numAdded) - atomic.LoadUint64(&e.numProcessed)
 }
 
-type messageToDispatch struct {
-	ctx  context.Context
-	next string
-	done chan error
+type message[S comparable, P any] struct {
+	ctx    context.Context
+	region string
+	next   S
+	event  string
+	done   chan error
+	final  context.Context // context of the successful transition (set before done)
+
+	// payload of the transition, it is replaced by the payload of the
+	// successful transition (set before done)
+	payload P
+
+	enqueued time.Time
+	dryRun   bool                        // execute only guards (see CanDispatch)
+	result   *DispatchResultOf[S, P]     // collected result (nil if it is not requested)
+	results  chan DispatchResultOf[S, P] // receives result (set before done)
 }
 
-func (e *FSM) Describe(ch chan<- *prometheus.Desc) {
-	e.mActionDuration.Describe(ch)
-	e.mTotalDuration.Describe(ch)
-	e.mActionRequest.Describe(ch)
-	e.mTotalRequest.Describe(ch)
+func (e *FSMOf[S, P]) Describe(ch chan<- *prometheus.Desc) {
+	ch <- regionStateDesc
+	if e.engine == nil {
+		e.metrics.Describe(ch)
+	}
 }
 
-func (e *FSM) Collect(ch chan<- prometheus.Metric) {
-	e.mActionDuration.Collect(ch)
-	e.mTotalDuration.Collect(ch)
-	e.mActionRequest.Collect(ch)
-	e.mTotalRequest.Collect(ch)
+func (e *FSMOf[S, P]) Collect(ch chan<- prometheus.Metric) {
+	for region, state := range e.Configuration() {
+		ch <- prometheus.MustNewConstMetric(regionStateDesc, prometheus.GaugeValue, 1, e.name, region, StateName(state))
+	}
+	if e.engine == nil {
+		e.metrics.Collect(ch)
+	}
 }
 
 var _ prometheus.Collector = (*FSM)(nil)
 
+func (m *metrics) Describe(ch chan<- *prometheus.Desc) {
+	m.mActionDuration.Describe(ch)
+	m.mTotalDuration.Describe(ch)
+	m.mActionRequest.Describe(ch)
+	m.mActionAttempt.Describe(ch)
+	m.mTotalRequest.Describe(ch)
+}
+
+func (m *metrics) Collect(ch chan<- prometheus.Metric) {
+	m.mActionDuration.Collect(ch)
+	m.mTotalDuration.Collect(ch)
+	m.mActionRequest.Collect(ch)
+	m.mActionAttempt.Collect(ch)
+	m.mTotalRequest.Collect(ch)
+}
+
 // UnknownState it is value is an undefined state.
 const UnknownState = ""
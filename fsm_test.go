@@ -8,6 +8,7 @@ import (
 	"time"
 
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 )
 
 func Test_FSM_Simple(t *testing.T) {
@@ -225,7 +226,8 @@ func Test_FSM_TransitionWithHandlers(t *testing.T) {
 				Add(CloseDoor, OpenDoor, door.IfAnonymThenBob).
 				Add(CloseDoor, OpenDoor, door.AccessOnlyBob),
 			ctx: func() context.Context {
-				ctx, _ := context.WithTimeout(context.Background(), 0)
+				ctx, cancel := context.WithTimeout(context.Background(), 0)
+				defer cancel() // already expired with deadline exceeded
 				return ctx
 			}(),
 			initState:   CloseDoor,
@@ -280,6 +282,509 @@ func Test_FSM_TransitionWithHandlers(t *testing.T) {
 	time.Sleep(time.Second)
 }
 
+func Test_FSM_DispatchEvent(t *testing.T) {
+	door := &door{}
+	wf := make(Stack).
+		AddEvent(CloseDoor, "knock", TokTokDoor).
+		AddEvent(TokTokDoor, "open", OpenDoor, door.AccessOnlyBob).
+		AddEvent(OpenDoor, "close", CloseDoor, func(ctx context.Context) (context.Context, error) {
+			assert.Equal(t, OpenDoor, GetSrcState(ctx))
+			assert.Equal(t, CloseDoor, GetDstState(ctx))
+			assert.Equal(t, "close", GetEvent(ctx))
+			return ctx, nil
+		})
+	fsm := NewFSM(wf, CloseDoor)
+
+	err := fsm.DispatchEvent(context.Background(), "open")
+	assert.True(t, errors.Is(err, ErrNotRegTransition))
+	assert.Contains(t, err.Error(), `"open"`)
+	assert.Equal(t, CloseDoor, fsm.State())
+
+	err = fsm.DispatchEvent(context.Background(), "knock")
+	assert.NoError(t, err)
+	assert.Equal(t, TokTokDoor, fsm.State())
+
+	err = fsm.DispatchEvent(context.Background(), "open")
+	assert.EqualError(t, err, `access denied ("toktok"=>"open" by "open" #0)`)
+	assert.Equal(t, TokTokDoor, fsm.State())
+
+	err = fsm.DispatchEvent(context.WithValue(context.Background(), "__name", "bob"), "open")
+	assert.NoError(t, err)
+	assert.Equal(t, OpenDoor, fsm.State())
+
+	// the transition registered by event is not available by destination state
+	err = fsm.Dispatch(context.Background(), CloseDoor)
+	assert.True(t, errors.Is(err, ErrNotRegTransition))
+
+	err = fsm.DispatchEvent(context.Background(), "close")
+	assert.NoError(t, err)
+	assert.Equal(t, CloseDoor, fsm.State())
+}
+
+func Test_FSM_DispatchPayload(t *testing.T) {
+	type visit struct {
+		Name   string
+		Visits int
+	}
+	ifAnonymThenBob := func(ctx context.Context, payload interface{}) (interface{}, error) {
+		v, ok := payload.(visit)
+		if !ok {
+			return nil, errors.New("expected visit")
+		}
+		if v.Name == "" {
+			v.Name = "bob"
+		}
+		return v, nil
+	}
+	count := func(ctx context.Context, payload interface{}) (interface{}, error) {
+		v, ok := payload.(visit)
+		if !ok {
+			return nil, errors.New("expected visit")
+		}
+		v.Visits++
+		return v, nil
+	}
+	wf := make(Stack).
+		AddAction(CloseDoor, OpenDoor, Action{Name: "ifAnonymThenBob", PayloadProcedure: ifAnonymThenBob}).
+		OnEnterAction(OpenDoor, Action{Name: "count", PayloadProcedure: count}).
+		Add(OpenDoor, CloseDoor).
+		AddEvent(OpenDoor, "break", "broken", door{}.AbortOpen)
+	fsm := NewFSM(wf, CloseDoor)
+	defer fsm.Stop()
+
+	res, err := fsm.DispatchPayload(context.Background(), OpenDoor, visit{Visits: 1})
+	require.NoError(t, err)
+	assert.Equal(t, visit{Name: "bob", Visits: 2}, res)
+
+	// without procedures the payload is returned as is
+	res, err = fsm.DispatchPayload(context.Background(), CloseDoor, res)
+	require.NoError(t, err)
+	assert.Equal(t, visit{Name: "bob", Visits: 2}, res)
+
+	res, err = fsm.DispatchPayload(context.Background(), OpenDoor, visit{Name: "alice"})
+	require.NoError(t, err)
+	assert.Equal(t, visit{Name: "alice", Visits: 1}, res)
+
+	res, err = fsm.DispatchEventPayload(context.Background(), "break", visit{})
+	assert.EqualError(t, err, `abort open door ("open"=>"broken" by "break" #0)`)
+	assert.Nil(t, res)
+
+	// the payload of unexpected type is the error, not the panic
+	fsm.SetState(CloseDoor)
+	res, err = fsm.DispatchPayload(context.Background(), OpenDoor, "visit")
+	var dispatchErr DispatchError
+	require.True(t, errors.As(err, &dispatchErr))
+	assert.Contains(t, dispatchErr.ActionName, "ifAnonymThenBob")
+	assert.EqualError(t, dispatchErr.Err, "expected visit")
+	assert.Nil(t, res)
+	assert.Equal(t, CloseDoor, fsm.State())
+}
+
+func Test_FSM_Guards(t *testing.T) {
+	door := &door{}
+	var written []string
+	write := func(ctx context.Context) (context.Context, error) {
+		written = append(written, GetDstState(ctx))
+		return ctx, nil
+	}
+	wf := make(Stack).
+		Add(CloseDoor, OpenDoor, write). // registered before guard but executed after it
+		AddGuard(CloseDoor, OpenDoor, door.GuardOnlyBob).
+		Add(OpenDoor, CloseDoor, write).
+		AddEventGuard(OpenDoor, "close", CloseDoor, door.GuardOnlyBob)
+	fsm := NewFSM(wf, CloseDoor)
+
+	err := fsm.Dispatch(context.Background(), OpenDoor)
+	assert.True(t, errors.Is(err, ErrGuardRejected))
+	var guardErr GuardError
+	assert.True(t, errors.As(err, &guardErr))
+	assert.Equal(t, CloseDoor, guardErr.SrcState)
+	assert.Equal(t, OpenDoor, guardErr.DstState)
+	assert.EqualError(t, guardErr.Err, "access denied")
+	assert.Empty(t, written)
+	assert.Equal(t, CloseDoor, fsm.State())
+
+	bobCtx := context.WithValue(context.Background(), "__name", "bob")
+	err = fsm.Dispatch(bobCtx, OpenDoor)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{OpenDoor}, written)
+	assert.Equal(t, OpenDoor, fsm.State())
+
+	// the guard of event transition does not affect transition by destination state
+	err = fsm.Dispatch(context.Background(), CloseDoor)
+	assert.NoError(t, err)
+	assert.Equal(t, CloseDoor, fsm.State())
+}
+
+func Test_FSM_StateHooks(t *testing.T) {
+	door := &door{}
+	var calls []string
+	trace := func(name string) Procedure {
+		return func(ctx context.Context) (context.Context, error) {
+			calls = append(calls, name+":"+GetSrcState(ctx)+"->"+GetDstState(ctx))
+			return ctx, nil
+		}
+	}
+	wf := make(Stack).
+		Add(CloseDoor, OpenDoor, trace("action")).
+		AddGuard(CloseDoor, OpenDoor, func(ctx context.Context) error {
+			calls = append(calls, "guard")
+			return nil
+		}).
+		Add(OpenDoor, CloseDoor).
+		Add(OpenDoor, TokTokDoor).
+		OnExit(CloseDoor, trace("exit")).
+		OnEnter(OpenDoor, trace("enter")).
+		OnEnter(TokTokDoor, door.AbortOpen)
+	fsm := NewFSM(wf, CloseDoor)
+
+	err := fsm.Dispatch(context.Background(), OpenDoor)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{
+		"guard",
+		"exit:close->open",
+		"action:close->open",
+		"enter:close->open",
+	}, calls)
+
+	// hook of entering aborts the transition
+	err = fsm.Dispatch(context.Background(), TokTokDoor)
+	assert.EqualError(t, err, `abort open door ("open"=>"toktok" on enter #0)`)
+	assert.Equal(t, OpenDoor, fsm.State())
+
+	calls = nil
+	err = fsm.Dispatch(context.Background(), CloseDoor)
+	assert.NoError(t, err)
+	assert.Empty(t, calls)
+}
+
+func Test_FSM_Wildcard(t *testing.T) {
+	door := &door{}
+	const Broken = "broken"
+	wf := make(Stack).
+		Add(CloseDoor, OpenDoor).
+		Add(OpenDoor, CloseDoor).
+		Add(AnyState, Broken).
+		AddGuard(Broken, AnyState, door.GuardOnlyBob)
+	fsm := NewFSM(wf, CloseDoor)
+
+	assert.NoError(t, fsm.Dispatch(context.Background(), Broken))
+	assert.Equal(t, Broken, fsm.State())
+
+	err := fsm.Dispatch(context.Background(), OpenDoor)
+	assert.True(t, errors.Is(err, ErrGuardRejected))
+
+	err = fsm.Dispatch(context.Background(), AnyState)
+	assert.True(t, errors.Is(err, ErrNotRegTransition))
+
+	bobCtx := context.WithValue(context.Background(), "__name", "bob")
+	assert.NoError(t, fsm.Dispatch(bobCtx, OpenDoor))
+	assert.Equal(t, OpenDoor, fsm.State())
+}
+
+func Test_FSM_DispatchError(t *testing.T) {
+	door := &door{}
+	wf := make(Stack).
+		AddAction(CloseDoor, OpenDoor, NamedGuard("onlyBob", door.GuardOnlyBob)).
+		AddEventAction(OpenDoor, "close", CloseDoor,
+			Action{Name: "lock", Procedure: door.Empty, Compensate: door.AbortOpen},
+			Named("abort", door.AbortOpen),
+		)
+	fsm := NewFSM(wf, CloseDoor)
+	defer fsm.Stop()
+	bobCtx := context.WithValue(context.Background(), "__name", "bob")
+
+	var dispatchErr DispatchError
+	err := fsm.Dispatch(context.Background(), OpenDoor)
+	require.True(t, errors.As(err, &dispatchErr))
+	assert.Equal(t, "onlyBob", dispatchErr.ActionName)
+	assert.True(t, dispatchErr.Guard)
+	assert.Equal(t, CloseDoor, dispatchErr.SrcState)
+	assert.Equal(t, OpenDoor, dispatchErr.DstState)
+	assert.True(t, errors.Is(err, ErrGuardRejected))
+	var guardErr GuardError
+	require.True(t, errors.As(err, &guardErr))
+	assert.EqualError(t, guardErr.Err, "access denied")
+	assert.EqualError(t, err, `guard rejected transition "close"=>"open" #0: access denied`)
+
+	require.NoError(t, fsm.Dispatch(bobCtx, OpenDoor))
+	err = fsm.DispatchEvent(context.Background(), "close")
+	require.True(t, errors.As(err, &dispatchErr))
+	assert.Equal(t, "abort", dispatchErr.ActionName)
+	assert.Equal(t, 1, dispatchErr.Index)
+	assert.Equal(t, "close", dispatchErr.Event)
+	require.Len(t, dispatchErr.Compensations, 1)
+	assert.Equal(t, "lock", dispatchErr.Compensations[0].ActionName)
+	assert.EqualError(t, err, `abort open door ("open"=>"close" by "close" #1); compensation lock failed: abort open door`)
+
+	// sentinel errors
+	err = fsm.DispatchEvent(context.Background(), "knock")
+	require.True(t, errors.As(err, &dispatchErr))
+	assert.True(t, errors.Is(err, ErrNotRegTransition))
+	assert.Equal(t, DispatchError{
+		SrcState: OpenDoor,
+		Event:    "knock",
+		Index:    -1,
+		Err:      dispatchErr.Err,
+	}, dispatchErr)
+	assert.EqualError(t, err, `Not registred transition: event "knock" from "open" ("open" by "knock")`)
+
+	// the same format for the destination state
+	err = fsm.Dispatch(context.Background(), TokTokDoor)
+	require.True(t, errors.As(err, &dispatchErr))
+	assert.True(t, errors.Is(err, ErrNotRegTransition))
+	assert.Equal(t, TokTokDoor, dispatchErr.DstState)
+	assert.EqualError(t, err, `Not registred transition ("open"=>"toktok")`)
+
+	err = fsm.DispatchRegion(context.Background(), "light", "on")
+	require.True(t, errors.As(err, &dispatchErr))
+	assert.True(t, errors.Is(err, ErrNotRegRegion))
+	assert.Equal(t, "light", dispatchErr.Region)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	err = fsm.DispatchEvent(ctx, "close")
+	require.True(t, errors.As(err, &dispatchErr))
+	assert.True(t, errors.Is(err, ErrCtxCanceled))
+	assert.True(t, errors.Is(err, context.Canceled))
+	assert.Equal(t, OpenDoor, dispatchErr.SrcState)
+	assert.Equal(t, CloseDoor, dispatchErr.DstState)
+	assert.EqualError(t, err, `context canceled ("open"=>"close" by "close")`)
+
+	fsm.SetState(UnknownState)
+	err = fsm.Dispatch(context.Background(), OpenDoor)
+	require.True(t, errors.As(err, &dispatchErr))
+	assert.True(t, errors.Is(err, ErrNotInitalState))
+	assert.Equal(t, OpenDoor, dispatchErr.DstState)
+}
+
+func Test_FSM_Compensations(t *testing.T) {
+	door := &door{}
+	var calls []string
+	trace := func(name string) Procedure {
+		return func(ctx context.Context) (context.Context, error) {
+			calls = append(calls, name)
+			return ctx, nil
+		}
+	}
+	wf := make(Stack).
+		AddAction(CloseDoor, OpenDoor,
+			Action{Procedure: trace("reserve"), Compensate: trace("release")},
+			Action{Procedure: trace("log")},
+			Action{Procedure: trace("charge"), Compensate: func(ctx context.Context) (context.Context, error) {
+				calls = append(calls, "refund")
+				return ctx, errors.New("refund failed")
+			}},
+			Action{Procedure: door.Panic, Compensate: trace("not executed")},
+		).
+		AddAction(OpenDoor, CloseDoor,
+			Action{Procedure: trace("lock"), Compensate: trace("unlock")},
+			Action{Procedure: door.AbortOpen},
+		)
+
+	fsm := NewFSM(wf, CloseDoor)
+	err := fsm.Dispatch(context.Background(), OpenDoor)
+	assert.Equal(t, []string{"reserve", "log", "charge", "refund", "release"}, calls)
+	assert.Equal(t, CloseDoor, fsm.State())
+
+	var dispatchErr DispatchError
+	require.True(t, errors.As(err, &dispatchErr))
+	assert.True(t, dispatchErr.IsPanic)
+	assert.Equal(t, 3, dispatchErr.Index)
+	require.Len(t, dispatchErr.Compensations, 2)
+	assert.Equal(t, 2, dispatchErr.Compensations[0].Index)
+	assert.EqualError(t, dispatchErr.Compensations[0].Err, "refund failed")
+	assert.Equal(t, 0, dispatchErr.Compensations[1].Index)
+	assert.NoError(t, dispatchErr.Compensations[1].Err)
+	assert.Contains(t, err.Error(), "refund failed")
+
+	calls = nil
+	fsm.SetState(OpenDoor)
+	err = fsm.Dispatch(context.Background(), CloseDoor)
+	assert.Equal(t, []string{"lock", "unlock"}, calls)
+	assert.EqualError(t, err, `abort open door ("open"=>"close" #1)`)
+	assert.Equal(t, OpenDoor, fsm.State())
+}
+
+func Test_FSM_Timeouts(t *testing.T) {
+	hang := func(ctx context.Context) (context.Context, error) {
+		time.Sleep(time.Second) // ignores context
+		return ctx, nil
+	}
+	wait := func(ctx context.Context) (context.Context, error) {
+		<-ctx.Done()
+		return ctx, ctx.Err()
+	}
+	var released bool
+	wf := make(Stack).
+		AddAction(CloseDoor, OpenDoor,
+			Action{
+				Procedure: func(ctx context.Context) (context.Context, error) {
+					return context.WithValue(ctx, "__name", "bob"), nil
+				},
+				Compensate: func(ctx context.Context) (context.Context, error) {
+					released = ctx.Err() == nil && ctx.Value("__name") == "bob"
+					return ctx, nil
+				},
+				Timeout: time.Second,
+			},
+			Action{Procedure: hang, Timeout: 10 * time.Millisecond},
+		).
+		Add(CloseDoor, TokTokDoor, wait).
+		AddAction(OpenDoor, CloseDoor,
+			Action{Procedure: (door{}).IfAnonymThenBob, Timeout: time.Second},
+			Action{Procedure: func(ctx context.Context) (context.Context, error) {
+				return ctx, ctx.Err()
+			}},
+			Action{Procedure: (door{}).AccessOnlyBob},
+		)
+
+	fsm := NewFSM(wf, CloseDoor)
+	start := time.Now()
+	err := fsm.Dispatch(context.Background(), OpenDoor)
+	assert.True(t, time.Since(start) < 500*time.Millisecond)
+	assert.True(t, errors.Is(err, ErrTimeout))
+	var dispatchErr DispatchError
+	require.True(t, errors.As(err, &dispatchErr))
+	assert.Equal(t, 1, dispatchErr.Index)
+	assert.True(t, released, "compensation with values of context and without cancellation")
+	assert.Equal(t, CloseDoor, fsm.State())
+
+	fsm.SetTransitionTimeout(10 * time.Millisecond)
+	err = fsm.Dispatch(context.Background(), TokTokDoor)
+	assert.True(t, errors.Is(err, ErrTimeout))
+	assert.Contains(t, err.Error(), "transition deadline exceeded")
+	assert.Equal(t, CloseDoor, fsm.State())
+
+	// context of the next action is not canceled after the action with timeout
+	fsm.SetTransitionTimeout(0)
+	fsm.SetState(OpenDoor)
+	assert.NoError(t, fsm.Dispatch(context.Background(), CloseDoor))
+}
+
+func Test_FSM_TimeoutOfTransition(t *testing.T) {
+	wait := func(d time.Duration) Procedure {
+		return func(ctx context.Context) (context.Context, error) {
+			select {
+			case <-time.After(d):
+				return ctx, nil
+			case <-ctx.Done():
+				return ctx, ctx.Err()
+			}
+		}
+	}
+	wf := make(Stack).
+		Add(CloseDoor, OpenDoor, wait(50*time.Millisecond)).
+		Add(OpenDoor, CloseDoor, wait(50*time.Millisecond)).
+		SetTimeout(OpenDoor, CloseDoor, time.Second).
+		AddEvent("active", "knock", TokTokDoor, wait(50*time.Millisecond)).
+		SetEventTimeout("active", "knock", 10*time.Millisecond)
+
+	key, ok := wf.Resolve("active.picking", UnknownState, "knock")
+	require.True(t, ok)
+	timeout, ok := wf.Timeout(key)
+	assert.True(t, ok)
+	assert.Equal(t, 10*time.Millisecond, timeout)
+	_, ok = wf.Timeout(StackKey{Src: CloseDoor, Dst: OpenDoor})
+	assert.False(t, ok)
+
+	fsm := NewFSM(wf, CloseDoor)
+	defer fsm.Stop()
+	fsm.SetTransitionTimeout(10 * time.Millisecond)
+
+	// the timeout of FSM is the default
+	err := fsm.Dispatch(context.Background(), OpenDoor)
+	assert.True(t, errors.Is(err, ErrTimeout))
+
+	// the timeout of the transition is used instead of it
+	fsm.SetState(OpenDoor)
+	assert.NoError(t, fsm.Dispatch(context.Background(), CloseDoor))
+
+	// the timeout is inherited by the child state
+	fsm.SetTransitionTimeout(0)
+	fsm.SetState("active.picking")
+	err = fsm.DispatchEvent(context.Background(), "knock")
+	assert.True(t, errors.Is(err, ErrTimeout))
+	assert.Equal(t, "active.picking", fsm.State())
+}
+
+type gate int
+
+const (
+	gateClosed gate = iota + 1 // zero value is the unknown state
+	gateOpened
+	gateBroken
+)
+
+func (g gate) String() string {
+	return [...]string{"unknown", "closed", "opened", "broken"}[g]
+}
+
+func Test_FSMOf(t *testing.T) {
+	crack := errors.New("crack")
+	var compensated int
+	wf := make(StackOf[gate, int]).
+		AddPayload(gateClosed, gateOpened, func(ctx context.Context, opens int) (int, error) {
+			assert.Equal(t, gateClosed, SrcStateOf[gate](ctx))
+			assert.Equal(t, gateOpened, DstStateOf[gate](ctx))
+			return opens + 1, nil
+		}).
+		Add(gateOpened, gateClosed).
+		AddGuard(gateOpened, gateBroken, func(ctx context.Context) error {
+			return errors.New("locked")
+		}).
+		AddEventAction(gateClosed, "kick", gateBroken, ActionOf[int]{
+			Name: "dent",
+			PayloadProcedure: func(ctx context.Context, force int) (int, error) {
+				return force * 2, nil
+			},
+			PayloadCompensate: func(ctx context.Context, force int) (int, error) {
+				compensated = force
+				return force, nil
+			},
+		}, ActionOf[int]{
+			Name: "crack",
+			PayloadProcedure: func(ctx context.Context, force int) (int, error) {
+				return 0, crack
+			},
+		})
+	assert.True(t, wf.Validate(gateClosed, nil, []gate{gateBroken}).OK())
+	assert.Contains(t, wf.DOT(DiagramOptions{Initial: StateName(gateClosed)}), `"opened" -> "closed";`)
+
+	fsm := NewFSMOf(wf, gateClosed)
+	defer fsm.Stop()
+	opens, err := fsm.DispatchPayload(context.Background(), gateOpened, 1)
+	require.NoError(t, err)
+	assert.Equal(t, 2, opens)
+	assert.Equal(t, gateOpened, fsm.State())
+
+	err = fsm.Dispatch(context.Background(), gateBroken)
+	assert.True(t, errors.Is(err, ErrGuardRejected))
+	var guardErr GuardError
+	require.True(t, errors.As(err, &guardErr))
+	assert.Equal(t, "opened", guardErr.SrcState)
+	assert.Equal(t, "broken", guardErr.DstState)
+
+	require.NoError(t, fsm.Dispatch(context.Background(), gateClosed))
+	force, err := fsm.DispatchEventPayload(context.Background(), "kick", 5)
+	assert.True(t, errors.Is(err, crack))
+	assert.Equal(t, 0, force)
+	assert.Equal(t, 10, compensated)
+	assert.Equal(t, gateClosed, fsm.State())
+
+	// states of not string kind are flat and have not wildcards
+	err = fsm.Dispatch(context.Background(), gate(0))
+	assert.True(t, errors.Is(err, ErrNotRegTransition))
+
+	engine := NewEngine(2)
+	defer engine.Stop()
+	efsm := NewEngineFSMOf(engine, wf, gateClosed)
+	opens, err = efsm.DispatchPayload(context.Background(), gateOpened, 3)
+	require.NoError(t, err)
+	assert.Equal(t, 4, opens)
+	assert.Equal(t, gateOpened, efsm.State())
+}
+
 func Test_FSM_FullState_ConcurrentDispatch(t *testing.T) {
 	door := &door{}
 	wf := make(Stack).Add(CloseDoor, OpenDoor, door.AccessOnlyBobWithoutDelay).
@@ -376,7 +881,6 @@ func (d door) IfAnonymThenBob(ctx context.Context) (context.Context, error) {
 
 func (d door) Panic(ctx context.Context) (context.Context, error) {
 	panic("door.Panic")
-	return ctx, nil
 }
 
 func (d door) Empty(ctx context.Context) (context.Context, error) {
@@ -399,6 +903,11 @@ func (d door) AccessOnlyBobWithoutDelay(ctx context.Context) (context.Context, e
 	return ctx, nil
 }
 
+func (d door) GuardOnlyBob(ctx context.Context) error {
+	_, err := d.AccessOnlyBobWithoutDelay(ctx)
+	return err
+}
+
 func (d door) AbortOpen(ctx context.Context) (context.Context, error) {
 	return ctx, errors.New("abort open door")
 }
@@ -1,8 +1,24 @@
 module github.com/gebv/ffsm
 
-go 1.13
+go 1.18
 
 require (
+	github.com/mattn/go-sqlite3 v1.14.6
 	github.com/prometheus/client_golang v1.2.1
 	github.com/stretchr/testify v1.4.0
+	gopkg.in/yaml.v3 v3.0.1
+)
+
+require (
+	github.com/beorn7/perks v1.0.1 // indirect
+	github.com/cespare/xxhash/v2 v2.1.0 // indirect
+	github.com/davecgh/go-spew v1.1.1 // indirect
+	github.com/golang/protobuf v1.3.2 // indirect
+	github.com/matttproud/golang_protobuf_extensions v1.0.1 // indirect
+	github.com/pmezard/go-difflib v1.0.0 // indirect
+	github.com/prometheus/client_model v0.0.0-20190812154241-14fe0d1b01d4 // indirect
+	github.com/prometheus/common v0.7.0 // indirect
+	github.com/prometheus/procfs v0.0.5 // indirect
+	golang.org/x/sys v0.0.0-20191010194322-b09406accb47 // indirect
+	gopkg.in/yaml.v2 v2.2.2 // indirect
 )
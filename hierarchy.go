@@ -0,0 +1,241 @@
+package ffsm
+
+import (
+	"encoding"
+	"fmt"
+	"reflect"
+	"strings"
+)
+
+// StateSeparator separates names of the parent state and the child state.
+// For example "active.picking" is the child of "active". Empty separator
+// turns off the hierarchy, all states are flat (as before hierarchical
+// states were added).
+//
+// NOTE: set this value before setup Stack.
+var StateSeparator = "."
+
+// ParentState returns parent of the state or UnknownState for top-level state.
+func ParentState(state string) string {
+	if state == AnyState || StateSeparator == "" {
+		return UnknownState
+	}
+	i := strings.LastIndex(state, StateSeparator)
+	if i <= 0 {
+		return UnknownState
+	}
+	return state[:i]
+}
+
+// StatePath returns path of the state from top-level state to the state itself.
+// For example "active.picking" has path ["active", "active.picking"].
+func StatePath(state string) []string {
+	if state == UnknownState {
+		return nil
+	}
+	var path []string
+	for s := state; s != UnknownState; s = ParentState(s) {
+		path = append(path, s)
+	}
+	for i, j := 0, len(path)-1; i < j; i, j = i+1, j-1 {
+		path[i], path[j] = path[j], path[i]
+	}
+	return path
+}
+
+// isDescendant reports whether state is the descendant of the parent.
+func isDescendant[S comparable](state, parent S) bool {
+	s, ok := stateString(state)
+	p, parentOK := stateString(parent)
+	return ok && parentOK && StateSeparator != "" && strings.HasPrefix(s, p+StateSeparator)
+}
+
+// parentState returns parent of the state of string kind (see ParentState),
+// zero value for top-level state and states of other kinds.
+func parentState[S comparable](state S) S {
+	var unknown S
+	name, ok := stateString(state)
+	if !ok {
+		return unknown
+	}
+	parent, _ := stringState[S](ParentState(name))
+	return parent
+}
+
+// statePath returns path of the state (see StatePath), states of not string
+// kind have not parent states.
+func statePath[S comparable](state S) []S {
+	var unknown S
+	if state == unknown {
+		return nil
+	}
+	var path []S
+	for s := state; s != unknown; s = parentState(s) {
+		path = append(path, s)
+	}
+	for i, j := 0, len(path)-1; i < j; i, j = i+1, j-1 {
+		path[i], path[j] = path[j], path[i]
+	}
+	return path
+}
+
+// anyState returns AnyState of the type. Returns false if the type is not
+// of string kind.
+func anyState[S comparable]() (S, bool) {
+	return stringState[S](AnyState)
+}
+
+func isAnyState[S comparable](state S) bool {
+	wildcard, ok := anyState[S]()
+	return ok && state == wildcard
+}
+
+// stateString returns the state of string kind (string or the type with
+// underlying type string) as string.
+func stateString[S comparable](state S) (string, bool) {
+	if s, ok := interface{}(state).(string); ok {
+		return s, true
+	}
+	v := reflect.ValueOf(state)
+	if v.Kind() != reflect.String {
+		return "", false
+	}
+	return v.String(), true
+}
+
+// stringState returns the state of string kind by the name. Returns false if
+// the type is not of string kind.
+func stringState[S comparable](name string) (S, bool) {
+	var state S
+	if s, ok := interface{}(&state).(*string); ok {
+		*s = name
+		return state, true
+	}
+	v := reflect.ValueOf(&state).Elem()
+	if v.Kind() != reflect.String {
+		return state, false
+	}
+	v.SetString(name)
+	return state, true
+}
+
+// StateName returns the name of the state for errors, metrics, journals,
+// stores, diagrams and definitions: the state of string kind is the name as
+// is, the state implemented encoding.TextMarshaler is marshaled, other states
+// are formatted by fmt.Sprint. The zero value is UnknownState.
+func StateName[S comparable](state S) string {
+	if s, ok := stateString(state); ok {
+		return s
+	}
+	var unknown S
+	if state == unknown {
+		return UnknownState
+	}
+	if m, ok := interface{}(state).(encoding.TextMarshaler); ok {
+		if text, err := m.MarshalText(); err == nil {
+			return string(text)
+		}
+	}
+	return fmt.Sprint(state)
+}
+
+func stateNames[S comparable](states []S) []string {
+	if states == nil {
+		return nil
+	}
+	names := make([]string, len(states))
+	for i, s := range states {
+		names[i] = StateName(s)
+	}
+	return names
+}
+
+// ParseStateName returns the state by the name (see StateName). The state of
+// not string kind must implement encoding.TextUnmarshaler, otherwise it fails
+// with ErrStateName.
+func ParseStateName[S comparable](name string) (S, error) {
+	if state, ok := stringState[S](name); ok {
+		return state, nil
+	}
+	var state S
+	if name == UnknownState {
+		return state, nil
+	}
+	if u, ok := interface{}(&state).(encoding.TextUnmarshaler); ok {
+		if err := u.UnmarshalText([]byte(name)); err != nil {
+			return state, fmt.Errorf("%w: %q: %v", ErrStateName, name, err)
+		}
+		return state, nil
+	}
+	return state, fmt.Errorf("%w: %q of %T", ErrStateName, name, state)
+}
+
+// SetInitial registration initial child state of the parent state. Entering
+// the parent state resolves to its initial child.
+func (r StackOf[S, P]) SetInitial(parent, child S) StackOf[S, P] {
+	if r == nil {
+		panic("Stack.SetInitial: stack is empty")
+	}
+	if !isDescendant(child, parent) {
+		panic(fmt.Sprintf("Stack.SetInitial: %q is not child of %q", StateName(child), StateName(parent)))
+	}
+	for k := range r {
+		if k.Kind == InitialKind && k.Src == parent {
+			delete(r, k)
+		}
+	}
+
+	r[StackKeyOf[S]{Src: parent, Dst: child, Kind: InitialKind}] = []ActionOf[P]{}
+
+	return r
+}
+
+// Initial returns the state which is entered by entering the state: the
+// initial child state (recursively) or the state itself if it does not have
+// initial child.
+func (r StackOf[S, P]) Initial(state S) S {
+	if r == nil {
+		panic("Stack.Initial: stack is empty")
+	}
+	return r.initial(state)
+}
+
+func (r StackOf[S, P]) initial(state S) S {
+	for {
+		child, ok := r.initialChild(state)
+		if !ok {
+			return state
+		}
+		state = child
+	}
+}
+
+func (r StackOf[S, P]) initialChild(state S) (S, bool) {
+	for k := range r {
+		if k.Kind == InitialKind && k.Src == state {
+			return k.Dst, true
+		}
+	}
+	var unknown S
+	return unknown, false
+}
+
+// lcaDepth returns number of the common states of paths of src and target
+// which are not exited (and not entered) by the transition. It is the depth
+// of the least common ancestor which is the proper ancestor of both states.
+func lcaDepth[S comparable](src, target []S) int {
+	n := 0
+	for n < len(src) && n < len(target) && src[n] == target[n] {
+		n++
+	}
+	if n > len(src)-1 {
+		n = len(src) - 1
+	}
+	if n > len(target)-1 {
+		n = len(target) - 1
+	}
+	if n < 0 {
+		n = 0
+	}
+	return n
+}
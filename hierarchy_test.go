@@ -0,0 +1,149 @@
+package ffsm
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestStatePath(t *testing.T) {
+	assert.Nil(t, StatePath(UnknownState))
+	assert.Equal(t, []string{"a"}, StatePath("a"))
+	assert.Equal(t, []string{"a", "a.b", "a.b.c"}, StatePath("a.b.c"))
+	assert.Equal(t, "a.b", ParentState("a.b.c"))
+	assert.Equal(t, UnknownState, ParentState("a"))
+	assert.Equal(t, UnknownState, ParentState(AnyState))
+}
+
+func TestStateSeparator_Flat(t *testing.T) {
+	s := make(Stack).Add("order", "cancelled")
+	_, ok := s.Resolve("order.paid", "cancelled", "")
+	assert.True(t, ok)
+
+	StateSeparator = ""
+	defer func() { StateSeparator = "." }()
+	assert.Equal(t, UnknownState, ParentState("order.paid"))
+	assert.Equal(t, []string{"order.paid"}, StatePath("order.paid"))
+	_, ok = s.Resolve("order.paid", "cancelled", "")
+	assert.False(t, ok)
+}
+
+func TestStack_Initial(t *testing.T) {
+	s := make(Stack).
+		SetInitial("active", "active.picking").
+		SetInitial("active.picking", "active.picking.new")
+	assert.Equal(t, "active.picking.new", s.Initial("active"))
+	assert.Equal(t, "active.picking.new", s.Initial("active.picking"))
+	assert.Equal(t, "active.packing", s.Initial("active.packing"))
+
+	s.SetInitial("active", "active.packing")
+	assert.Equal(t, "active.packing", s.Initial("active"))
+
+	assert.Panics(t, func() {
+		s.SetInitial("active", "done")
+	})
+}
+
+func TestStack_ResolveInherited(t *testing.T) {
+	s := make(Stack).
+		Add("active", "cancelled").
+		Add("active.picking", AnyState).
+		Add(AnyState, "active").
+		AddEvent("active", "cancel", "cancelled").
+		AddEvent("active.packing", "cancel", "active.picking")
+
+	k, ok := s.Resolve("active.packing", "cancelled", "")
+	assert.True(t, ok)
+	assert.Equal(t, StackKey{Src: "active", Dst: "cancelled"}, k)
+
+	k, ok = s.Resolve("active.picking", "cancelled", "")
+	assert.True(t, ok)
+	assert.Equal(t, StackKey{Src: "active.picking", Dst: AnyState}, k)
+
+	k, ok = s.Resolve("done", "active", "")
+	assert.True(t, ok)
+	assert.Equal(t, StackKey{Src: AnyState, Dst: "active"}, k)
+
+	dst, ok := s.Target("active.picking", "cancel")
+	assert.True(t, ok)
+	assert.Equal(t, "cancelled", dst)
+
+	dst, ok = s.Target("active.packing", "cancel")
+	assert.True(t, ok)
+	assert.Equal(t, "active.picking", dst)
+}
+
+func Test_FSM_Hierarchical(t *testing.T) {
+	var calls []string
+	trace := func(name string) Procedure {
+		return func(ctx context.Context) (context.Context, error) {
+			calls = append(calls, name)
+			return ctx, nil
+		}
+	}
+	wf := make(Stack).
+		SetInitial("active", "active.picking").
+		Add("new", "active").
+		Add("active.picking", "active.packing").
+		Add("active", "cancelled").
+		OnEnter("active", trace("enter active")).
+		OnEnter("active.picking", trace("enter picking")).
+		OnExit("active.picking", trace("exit picking")).
+		OnEnter("active.packing", trace("enter packing")).
+		OnExit("active.packing", trace("exit packing")).
+		OnExit("active", trace("exit active"))
+	fsm := NewFSM(wf, "new")
+
+	assert.NoError(t, fsm.Dispatch(context.Background(), "active"))
+	assert.Equal(t, "active.picking", fsm.State())
+	assert.Equal(t, []string{"active", "active.picking"}, fsm.Path())
+	assert.True(t, fsm.In("active"))
+	assert.False(t, fsm.In("active.packing"))
+	assert.Equal(t, []string{"enter active", "enter picking"}, calls)
+
+	calls = nil
+	assert.NoError(t, fsm.Dispatch(context.Background(), "active.packing"))
+	assert.Equal(t, "active.packing", fsm.State())
+	assert.Equal(t, []string{"exit picking", "enter packing"}, calls)
+
+	// inherited from parent state
+	calls = nil
+	assert.NoError(t, fsm.Dispatch(context.Background(), "cancelled"))
+	assert.Equal(t, "cancelled", fsm.State())
+	assert.Equal(t, []string{"exit packing", "exit active"}, calls)
+
+	assert.Equal(t, "active.picking", NewFSM(wf, "active").State())
+}
+
+type phase string
+
+func TestStackOf_Hierarchy(t *testing.T) {
+	// states with underlying type string have hierarchy and wildcards
+	wf := make(StackOf[phase, struct{}]).
+		SetInitial("active", "active.picking").
+		Add("active.picking", "active.packing").
+		Add(AnyState, "done")
+	fsm := NewFSMOf(wf, "active")
+	defer fsm.Stop()
+	assert.Equal(t, phase("active.picking"), fsm.State())
+	assert.Equal(t, []phase{"active", "active.picking"}, fsm.Path())
+	assert.True(t, fsm.In("active"))
+
+	assert.NoError(t, fsm.Dispatch(context.Background(), "active.packing"))
+	assert.NoError(t, fsm.Dispatch(context.Background(), "done"))
+	assert.False(t, fsm.In("active"))
+}
+
+func TestStateName(t *testing.T) {
+	assert.Equal(t, "active.picking", StateName(phase("active.picking")))
+	assert.Equal(t, "opened", StateName(gateOpened))
+	assert.Equal(t, UnknownState, StateName(gate(0)))
+
+	s, err := ParseStateName[phase]("done")
+	assert.NoError(t, err)
+	assert.Equal(t, phase("done"), s)
+	_, err = ParseStateName[gate]("opened")
+	assert.True(t, errors.Is(err, ErrStateName))
+}
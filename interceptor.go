@@ -0,0 +1,86 @@
+package ffsm
+
+import (
+	"context"
+	"fmt"
+	"time"
+)
+
+// ActionInfo describes the action executed by the dispatcher. States are
+// names of states (see StateName).
+type ActionInfo struct {
+	Name     string // name of the action (empty if the action is not named)
+	Region   string
+	SrcState string
+	DstState string
+	Event    string
+	Kind     KeyKind // kind of the entry of Stack (transition or hook)
+	Index    int     // index of the action in list of the entry of Stack
+
+	Guard        bool // the action is the guard
+	Compensation bool // the action is the compensation
+	DryRun       bool // the guard is executed by CanDispatch
+}
+
+// String returns description of the action, for example
+// `"close" -> "open" by "open" #1 (check)`.
+func (i ActionInfo) String() string {
+	s := fmt.Sprintf("%q -> %q%s", i.SrcState, i.DstState, stepSuffix(i.Event, i.Kind, i.Index))
+	if i.Region != MainRegion {
+		s = fmt.Sprintf("[%s] %s", i.Region, s)
+	}
+	if i.Name != "" {
+		s += fmt.Sprintf(" (%s)", i.Name)
+	}
+	if i.Compensation {
+		s += " compensation"
+	}
+	return s
+}
+
+// Interceptor wraps execution of each action of transition (guards, hooks,
+// actions and compensations). It should call next to execute the action
+// and can change the result.
+type Interceptor func(ctx context.Context, info ActionInfo, next Procedure) (context.Context, error)
+
+// Use adds interceptors of actions. The first added interceptor is
+// the outermost. The built-in interceptor of prometheus metrics is always
+// the outermost.
+func (e *FSMOf[S, P]) Use(i ...Interceptor) {
+	e.stateMutex.Lock()
+	e.interceptors = append(e.interceptors, i...)
+	e.stateMutex.Unlock()
+}
+
+// intercept returns the procedure wrapped by all interceptors.
+func (e *FSMOf[S, P]) intercept(info ActionInfo, fn Procedure) Procedure {
+	e.stateMutex.RLock()
+	interceptors := e.interceptors
+	e.stateMutex.RUnlock()
+
+	for i := len(interceptors) - 1; i >= -1; i-- {
+		interceptor := e.metricsInterceptor
+		if i >= 0 {
+			interceptor = interceptors[i]
+		}
+		next := fn
+		fn = func(ctx context.Context) (context.Context, error) {
+			return interceptor(ctx, info, next)
+		}
+	}
+	return fn
+}
+
+// metricsInterceptor observes duration and number of executed actions
+// (except guards executed by CanDispatch).
+func (e *FSMOf[S, P]) metricsInterceptor(ctx context.Context, info ActionInfo, next Procedure) (context.Context, error) {
+	if info.DryRun {
+		return next(ctx)
+	}
+	actionStart := time.Now()
+	ctx, err := next(ctx)
+	actName := info.String()
+	e.mActionDuration.WithLabelValues(actName).Observe(float64(time.Since(actionStart).Nanoseconds() / int64(time.Millisecond)))
+	e.mActionRequest.WithLabelValues(actName).Inc()
+	return ctx, err
+}
@@ -0,0 +1,62 @@
+package ffsm
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_FSM_Interceptors(t *testing.T) {
+	door := &door{}
+	wf := make(Stack).
+		AddGuard(CloseDoor, OpenDoor, door.GuardOnlyBob).
+		AddAction(CloseDoor, OpenDoor, Action{Name: "abort", Procedure: door.AbortOpen, Compensate: door.Empty}).
+		AddAction(CloseDoor, OpenDoor, Action{Name: "empty", Procedure: door.Empty}).
+		OnEnter(OpenDoor, door.Empty)
+	fsm := NewFSM(wf, CloseDoor)
+
+	var log []string
+	fsm.Use(
+		func(ctx context.Context, info ActionInfo, next Procedure) (context.Context, error) {
+			log = append(log, "outer "+info.String())
+			return next(ctx)
+		},
+		func(ctx context.Context, info ActionInfo, next Procedure) (context.Context, error) {
+			ctx, err := next(ctx)
+			if info.Name == "abort" {
+				log = append(log, fmt.Sprintf("inner %s: %v", info.Name, err))
+				return ctx, nil // suppress error
+			}
+			return ctx, err
+		},
+	)
+
+	err := fsm.Dispatch(context.WithValue(context.Background(), "__name", "bob"), OpenDoor)
+	assert.NoError(t, err)
+	assert.Equal(t, OpenDoor, fsm.State())
+	assert.Equal(t, []string{
+		`outer "close" -> "open" #0`,
+		`outer "close" -> "open" #1 (abort)`,
+		`inner abort: abort open door`,
+		`outer "close" -> "open" #2 (empty)`,
+		`outer "close" -> "open" on enter #0`,
+	}, log)
+}
+
+func Test_FSM_InterceptorPanic(t *testing.T) {
+	fsm := NewFSM(make(Stack).Add(CloseDoor, OpenDoor, (door{}).Empty), CloseDoor)
+	fsm.Use(func(ctx context.Context, info ActionInfo, next Procedure) (context.Context, error) {
+		panic("interceptor panic")
+	})
+
+	err := fsm.Dispatch(context.Background(), OpenDoor)
+	var dispatchErr DispatchError
+	require.True(t, errors.As(err, &dispatchErr))
+	assert.True(t, dispatchErr.IsPanic)
+	assert.Contains(t, err.Error(), "interceptor panic")
+	assert.Equal(t, CloseDoor, fsm.State())
+}
@@ -0,0 +1,246 @@
+package ffsm
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// JournalActionName is the name of the action in DispatchError if the record
+// was not appended to the Journal.
+const JournalActionName = "journal"
+
+// JournalRecord is the record of the successful transition (with names of
+// states, see StateName).
+type JournalRecord struct {
+	Seq    uint64 // sequence number of the record, starts with 1
+	Region string
+	Src    string
+	Dst    string
+	Event  string
+	Time   time.Time
+	Meta   map[string]string // metadata of the event (see WithEventMeta)
+}
+
+// Snapshot is the configuration of FSM (states by name of region, see
+// FSM.Configuration) after the record with the sequence number Seq.
+type Snapshot struct {
+	Seq    uint64
+	States map[string]string
+}
+
+// Journal is the append-only log of transitions of FSM by ID of FSM.
+type Journal interface {
+	// Append appends the record. Append returns ConflictError if the
+	// sequence number of the record is not next after the last record. If
+	// Append fails the transition fails.
+	Append(ctx context.Context, id string, record JournalRecord) error
+
+	// Records returns records with sequence number greater than seq in order
+	// of sequence numbers.
+	Records(ctx context.Context, id string, seq uint64) ([]JournalRecord, error)
+
+	// SaveSnapshot saves the snapshot.
+	SaveSnapshot(ctx context.Context, id string, snapshot Snapshot) error
+
+	// LoadSnapshot returns the last saved snapshot or ErrStateNotFound.
+	LoadSnapshot(ctx context.Context, id string) (Snapshot, error)
+}
+
+// Replay rebuilds FSM from the journal without executing procedures: the last
+// snapshot and the records after it are applied to initial state. Each next
+// successful transition of FSM is appended to the journal (see
+// FSM.AttachJournal).
+//
+// States of regions are restored by FSM.AddRegion.
+func Replay(ctx context.Context, wf Stack, initState string, journal Journal, id string) (*FSM, error) {
+	e := NewFSM(wf, initState)
+	if err := e.AttachJournal(ctx, journal, id); err != nil {
+		e.Stop()
+		return nil, err
+	}
+	return e, nil
+}
+
+// AttachJournal attaches the journal to FSM (created by NewFSM, the Engine or
+// with the Store): the state is rebuilt from the journal as by Replay and each
+// next successful transition is appended to the journal. If the journal of
+// FSM is empty the current state is kept (for example loaded from the store).
+//
+// The journal is the source of truth of the state. If FSM also has the Store
+// the record is appended after the state is saved, so the transition failed
+// by the store is not in the journal. If Append fails after the save the
+// state of the store is ahead of the journal until the next transition (or
+// AttachJournal) overwrites it.
+//
+// If Append fails with ErrStateConflict (the journal was appended by another
+// instance of FSM) FSM is stale and it should be rebuilt (Manager evicts it).
+//
+// States of FSMOf are restored by ParseStateName.
+//
+// NOTE: attach the journal before dispatch. ID of FSM with the Store must be
+// the same.
+func (e *FSMOf[S, P]) AttachJournal(ctx context.Context, journal Journal, id string) error {
+	if e.id != "" && e.id != id {
+		return fmt.Errorf("journal %q of FSM %q", id, e.id)
+	}
+
+	snapshot, err := journal.LoadSnapshot(ctx, id)
+	empty := err == ErrStateNotFound
+	if empty {
+		snapshot = Snapshot{}
+	} else if err != nil {
+		return err
+	}
+	states := map[string]string{MainRegion: StateName(e.initState)}
+	for region, state := range snapshot.States {
+		states[region] = state
+	}
+
+	records, err := journal.Records(ctx, id, snapshot.Seq)
+	if err != nil {
+		return err
+	}
+	seq := snapshot.Seq
+	for _, record := range records {
+		if record.Seq != seq+1 {
+			return fmt.Errorf("%w: %q: record #%d after #%d", ErrBrokenJournal, id, record.Seq, seq)
+		}
+		if state, ok := states[record.Region]; ok && state != record.Src {
+			return fmt.Errorf("%w: %q: record #%d from %q, but state is %q", ErrBrokenJournal, id, record.Seq, record.Src, state)
+		}
+		states[record.Region] = record.Dst
+		seq = record.Seq
+	}
+
+	restored := map[string]S{}
+	if !empty || len(records) > 0 {
+		for region, name := range states {
+			if restored[region], err = ParseStateName[S](name); err != nil {
+				return err
+			}
+		}
+		e.stateMutex.Lock()
+		e.regions[0].state = restored[MainRegion]
+		e.stateMutex.Unlock()
+	}
+	delete(restored, MainRegion)
+	e.journal = journal
+	e.id = id
+	e.seq = seq
+	e.restored = restored
+	return nil
+}
+
+// SetSnapshotInterval sets number of records of journal between snapshots
+// (zero is without snapshots). Failed snapshot does not fail the transition.
+//
+// NOTE: set this value before dispatch.
+func (e *FSMOf[S, P]) SetSnapshotInterval(n uint64) {
+	e.snapshotInterval = n
+}
+
+// Seq returns sequence number of the last record of journal.
+//
+// NOTE: it is not thread-safe, use it only if FSM has not pending transitions.
+func (e *FSMOf[S, P]) Seq() uint64 {
+	return e.seq
+}
+
+func (e *FSMOf[S, P]) appendJournal(ctx context.Context, t transition) error {
+	if e.journal == nil {
+		return nil
+	}
+	record := JournalRecord{
+		Seq:    e.seq + 1,
+		Region: t.region,
+		Src:    t.src,
+		Dst:    t.dst,
+		Event:  t.event,
+		Time:   time.Now(),
+		Meta:   GetEventMeta(ctx),
+	}
+	if err := e.journal.Append(ctx, e.id, record); err != nil {
+		return err
+	}
+	e.seq = record.Seq
+	return nil
+}
+
+func (e *FSMOf[S, P]) snapshot(ctx context.Context) {
+	if e.journal == nil || e.snapshotInterval == 0 || e.seq%e.snapshotInterval != 0 {
+		return
+	}
+	// snapshot is the optimization of replay, the journal has all records
+	states := map[string]string{}
+	for region, state := range e.Configuration() {
+		states[region] = StateName(state)
+	}
+	_ = e.journal.SaveSnapshot(ctx, e.id, Snapshot{Seq: e.seq, States: states})
+}
+
+// NewMemoryJournal returns the journal which keeps records in memory.
+func NewMemoryJournal() *MemoryJournal {
+	return &MemoryJournal{
+		records:   map[string][]JournalRecord{},
+		snapshots: map[string]Snapshot{},
+	}
+}
+
+// MemoryJournal is the Journal which keeps records in memory (thread-safe).
+type MemoryJournal struct {
+	mu        sync.Mutex
+	records   map[string][]JournalRecord
+	snapshots map[string]Snapshot
+}
+
+// Append appends the record.
+func (j *MemoryJournal) Append(ctx context.Context, id string, record JournalRecord) error {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	records := j.records[id]
+	var last uint64
+	if len(records) > 0 {
+		last = records[len(records)-1].Seq
+	}
+	if record.Seq != last+1 {
+		return ConflictError{ID: id, Version: record.Seq - 1}
+	}
+	j.records[id] = append(records, record)
+	return nil
+}
+
+// Records returns records after the sequence number.
+func (j *MemoryJournal) Records(ctx context.Context, id string, seq uint64) ([]JournalRecord, error) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	var res []JournalRecord
+	for _, record := range j.records[id] {
+		if record.Seq > seq {
+			res = append(res, record)
+		}
+	}
+	return res, nil
+}
+
+// SaveSnapshot saves the snapshot.
+func (j *MemoryJournal) SaveSnapshot(ctx context.Context, id string, snapshot Snapshot) error {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	j.snapshots[id] = snapshot
+	return nil
+}
+
+// LoadSnapshot returns the last snapshot.
+func (j *MemoryJournal) LoadSnapshot(ctx context.Context, id string) (Snapshot, error) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	snapshot, ok := j.snapshots[id]
+	if !ok {
+		return Snapshot{}, ErrStateNotFound
+	}
+	return snapshot, nil
+}
+
+var _ Journal = (*MemoryJournal)(nil)
@@ -0,0 +1,195 @@
+package ffsm
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_FSM_Journal(t *testing.T) {
+	ctx := context.Background()
+	var executed int
+	wf := make(Stack).
+		Add(CloseDoor, OpenDoor, func(ctx context.Context) (context.Context, error) {
+			executed++
+			return ctx, nil
+		}).
+		Add(OpenDoor, CloseDoor, (door{}).AccessOnlyBob)
+	lock := make(Stack).Add("unlocked", "locked")
+	journal := NewMemoryJournal()
+
+	fsm, err := Replay(ctx, wf, CloseDoor, journal, "door")
+	require.NoError(t, err)
+	fsm.AddRegion("lock", lock, "unlocked")
+	fsm.SetSnapshotInterval(2)
+	assert.EqualValues(t, 0, fsm.Seq())
+
+	require.NoError(t, fsm.Dispatch(WithEventMeta(ctx, map[string]string{"user": "alice"}), OpenDoor))
+	assert.Error(t, fsm.Dispatch(ctx, CloseDoor)) // failed transition is not written
+	require.NoError(t, fsm.Dispatch(WithEventMeta(context.WithValue(ctx, "__name", "bob"), map[string]string{"user": "bob"}), CloseDoor))
+	require.NoError(t, fsm.DispatchRegion(ctx, "lock", "locked"))
+	require.NoError(t, fsm.Dispatch(ctx, OpenDoor))
+	assert.EqualValues(t, 4, fsm.Seq())
+	assert.Equal(t, 2, executed)
+	fsm.Stop()
+
+	records, err := journal.Records(ctx, "door", 0)
+	require.NoError(t, err)
+	require.Len(t, records, 4)
+	assert.Equal(t, JournalRecord{Seq: 1, Src: CloseDoor, Dst: OpenDoor, Meta: map[string]string{"user": "alice"}, Time: records[0].Time}, records[0])
+	assert.Equal(t, map[string]string{"user": "bob"}, records[1].Meta)
+	assert.Equal(t, "lock", records[2].Region)
+	assert.False(t, records[3].Time.Before(records[0].Time))
+
+	snapshot, err := journal.LoadSnapshot(ctx, "door")
+	require.NoError(t, err)
+	assert.Equal(t, Snapshot{Seq: 4, States: map[string]string{MainRegion: OpenDoor, "lock": "locked"}}, snapshot)
+
+	// replay without executing procedures
+	fsm, err = Replay(ctx, wf, CloseDoor, journal, "door")
+	require.NoError(t, err)
+	fsm.AddRegion("lock", lock, "unlocked")
+	assert.Equal(t, map[string]string{MainRegion: OpenDoor, "lock": "locked"}, fsm.Configuration())
+	assert.EqualValues(t, 4, fsm.Seq())
+	assert.Equal(t, 2, executed)
+
+	// replay from the records without snapshot
+	require.NoError(t, journal.SaveSnapshot(ctx, "door", Snapshot{Seq: 1, States: map[string]string{MainRegion: OpenDoor}}))
+	fsm, err = Replay(ctx, wf, CloseDoor, journal, "door")
+	require.NoError(t, err)
+	fsm.AddRegion("lock", lock, "unlocked")
+	assert.Equal(t, map[string]string{MainRegion: OpenDoor, "lock": "locked"}, fsm.Configuration())
+
+	// next transition continues the journal
+	require.NoError(t, fsm.Dispatch(context.WithValue(ctx, "__name", "bob"), CloseDoor))
+	records, err = journal.Records(ctx, "door", 4)
+	require.NoError(t, err)
+	require.Len(t, records, 1)
+	assert.EqualValues(t, 5, records[0].Seq)
+}
+
+func TestReplay_BrokenJournal(t *testing.T) {
+	ctx := context.Background()
+	wf := make(Stack).Add(CloseDoor, OpenDoor)
+
+	journal := NewMemoryJournal()
+	require.NoError(t, journal.Append(ctx, "door", JournalRecord{Seq: 1, Src: OpenDoor, Dst: CloseDoor}))
+	_, err := Replay(ctx, wf, CloseDoor, journal, "door")
+	assert.True(t, errors.Is(err, ErrBrokenJournal))
+
+	err = journal.Append(ctx, "door", JournalRecord{Seq: 3, Src: CloseDoor, Dst: OpenDoor})
+	assert.True(t, errors.Is(err, ErrStateConflict))
+}
+
+func Test_FSM_AttachJournal(t *testing.T) {
+	ctx := context.Background()
+	wf := make(Stack).
+		Add(CloseDoor, OpenDoor).
+		Add(OpenDoor, CloseDoor)
+	store := NewMemoryStore()
+	journal := NewMemoryJournal()
+	engine := NewEngine(1)
+	defer engine.Stop()
+
+	fsm, err := engine.NewFSMWithStore(ctx, wf, CloseDoor, store, "door")
+	require.NoError(t, err)
+	require.NoError(t, fsm.AttachJournal(ctx, journal, "door"))
+	require.NoError(t, fsm.Dispatch(ctx, OpenDoor))
+	assert.EqualValues(t, 1, fsm.Seq())
+	fsm.Stop()
+
+	record, err := store.Load(ctx, "door")
+	require.NoError(t, err)
+	assert.Equal(t, OpenDoor, record.State)
+
+	// the journal is the source of truth of the state
+	require.NoError(t, journal.Append(ctx, "door", JournalRecord{Seq: 2, Src: OpenDoor, Dst: CloseDoor}))
+	fsm, err = engine.NewFSMWithStore(ctx, wf, CloseDoor, store, "door")
+	require.NoError(t, err)
+	assert.Equal(t, OpenDoor, fsm.State())
+	require.NoError(t, fsm.AttachJournal(ctx, journal, "door"))
+	assert.Equal(t, CloseDoor, fsm.State())
+	require.NoError(t, fsm.Dispatch(ctx, OpenDoor))
+	assert.EqualValues(t, 3, fsm.Seq())
+
+	assert.Error(t, fsm.AttachJournal(ctx, journal, "other"))
+}
+
+// onceFailedStore fails the first save.
+type onceFailedStore struct {
+	Store
+	failed bool
+}
+
+func (s *onceFailedStore) Save(ctx context.Context, id string, prev Record, state string) (Record, error) {
+	if !s.failed {
+		s.failed = true
+		return Record{}, errors.New("store is unavailable")
+	}
+	return s.Store.Save(ctx, id, prev, state)
+}
+
+func Test_FSM_JournalStoreFailed(t *testing.T) {
+	ctx := context.Background()
+	wf := make(Stack).
+		Add(CloseDoor, OpenDoor).
+		Add(OpenDoor, CloseDoor)
+	store := &onceFailedStore{Store: NewMemoryStore()}
+	journal := NewMemoryJournal()
+
+	fsm, err := NewFSMWithStore(ctx, wf, CloseDoor, store, "door")
+	require.NoError(t, err)
+	defer fsm.Stop()
+	require.NoError(t, fsm.AttachJournal(ctx, journal, "door"))
+
+	err = fsm.Dispatch(ctx, OpenDoor)
+	var dispatchErr DispatchError
+	require.True(t, errors.As(err, &dispatchErr))
+	assert.Equal(t, StoreActionName, dispatchErr.ActionName)
+	assert.Equal(t, CloseDoor, fsm.State())
+	assert.EqualValues(t, 0, fsm.Seq())
+	records, err := journal.Records(ctx, "door", 0)
+	require.NoError(t, err)
+	assert.Empty(t, records)
+
+	require.NoError(t, fsm.Dispatch(ctx, OpenDoor))
+	require.NoError(t, fsm.Dispatch(ctx, CloseDoor))
+	assert.EqualValues(t, 2, fsm.Seq())
+
+	replayed, err := Replay(ctx, wf, CloseDoor, journal, "door")
+	require.NoError(t, err)
+	defer replayed.Stop()
+	assert.Equal(t, CloseDoor, replayed.State())
+	assert.EqualValues(t, 2, replayed.Seq())
+}
+
+func TestManager_Journal(t *testing.T) {
+	ctx := context.Background()
+	wf := make(Stack).
+		Add(CloseDoor, OpenDoor).
+		Add(OpenDoor, CloseDoor)
+	journal := NewMemoryJournal()
+	m := NewManager(wf, CloseDoor, nil)
+	defer m.Stop()
+	m.SetJournal(journal)
+
+	require.NoError(t, m.Dispatch(ctx, "door/1", OpenDoor))
+
+	// the record is appended by another instance, the instance is stale
+	require.NoError(t, journal.Append(ctx, "door/1", JournalRecord{Seq: 2, Src: OpenDoor, Dst: CloseDoor}))
+	err := m.Dispatch(ctx, "door/1", CloseDoor)
+	assert.True(t, errors.Is(err, ErrStateConflict))
+	assert.Equal(t, 0, m.Len())
+
+	// and it is rebuilt from the journal
+	state, err := m.State(ctx, "door/1")
+	require.NoError(t, err)
+	assert.Equal(t, CloseDoor, state)
+	require.NoError(t, m.Dispatch(ctx, "door/1", OpenDoor))
+	records, err := journal.Records(ctx, "door/1", 0)
+	require.NoError(t, err)
+	assert.Len(t, records, 3)
+}
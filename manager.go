@@ -0,0 +1,266 @@
+package ffsm
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+)
+
+// NewManager returns manager of FSM instances by ID which share the Stack.
+// Instances are created lazily with the state loaded from the store (or with
+// initial state) and are saved to the store after each transition. If the
+// store is nil the MemoryStore is used.
+func NewManager(wf Stack, initState string, store Store) *Manager {
+	if store == nil {
+		store = NewMemoryStore()
+	}
+	return &Manager{
+		wf:        wf,
+		initState: initState,
+		store:     store,
+		instances: map[string]*instance{},
+	}
+}
+
+// Manager manages FSM instances by ID (thread-safe). Transitions of the same
+// ID are executed in order of dispatch, idle instances are evicted (see
+// SetIdleTimeout) and their dispatchers are stopped. The instance that failed
+// with ErrStateConflict (the state was changed by another process) is evicted
+// too, so the next dispatch reloads the state from the store.
+type Manager struct {
+	wf        Stack
+	initState string
+	store     Store
+
+	mu        sync.Mutex
+	engine    *Engine
+	journal   Journal
+	instances map[string]*instance
+	onCreate  func(id string, fsm *FSM)
+	stopEvict chan struct{}
+	stopped   bool
+	wg        sync.WaitGroup
+}
+
+type instance struct {
+	id       string
+	ready    chan struct{} // closed when fsm is loaded
+	fsm      *FSM
+	err      error
+	refs     int // number of pending dispatches
+	lastUsed time.Time
+	stale    bool // evicted by conflict or Stop, stopped by the last release
+}
+
+// OnCreate sets the function called for each created instance before first
+// dispatch, for example to add interceptors or regions.
+func (m *Manager) OnCreate(fn func(id string, fsm *FSM)) {
+	m.mu.Lock()
+	m.onCreate = fn
+	m.mu.Unlock()
+}
+
+// SetEngine sets the engine of created instances (nil is the own dispatcher of
+// each instance). The engine is not stopped by Stop of the manager.
+func (m *Manager) SetEngine(g *Engine) {
+	m.mu.Lock()
+	m.engine = g
+	m.mu.Unlock()
+}
+
+// SetJournal sets the journal attached to created instances (see
+// FSM.AttachJournal), nil is without the journal.
+func (m *Manager) SetJournal(journal Journal) {
+	m.mu.Lock()
+	m.journal = journal
+	m.mu.Unlock()
+}
+
+// SetIdleTimeout sets timeout after which the instance without dispatches is
+// evicted (zero is without eviction). The check is executed in background
+// each half of the timeout.
+func (m *Manager) SetIdleTimeout(timeout time.Duration) {
+	m.mu.Lock()
+	if m.stopEvict != nil {
+		close(m.stopEvict)
+		m.stopEvict = nil
+	}
+	if timeout > 0 {
+		m.stopEvict = make(chan struct{})
+		m.wg.Add(1)
+		go m.evictLoop(timeout, m.stopEvict)
+	}
+	m.mu.Unlock()
+}
+
+func (m *Manager) evictLoop(timeout time.Duration, stop chan struct{}) {
+	defer m.wg.Done()
+	ticker := time.NewTicker(timeout / 2)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			m.Evict(timeout)
+		case <-stop:
+			return
+		}
+	}
+}
+
+// Evict evicts instances without dispatches for the duration and stops their
+// dispatchers. Returns number of evicted instances.
+func (m *Manager) Evict(idle time.Duration) int {
+	var evicted []*FSM
+	m.mu.Lock()
+	for id, inst := range m.instances {
+		select {
+		case <-inst.ready:
+		default:
+			continue // is loading
+		}
+		if inst.fsm != nil && inst.refs == 0 && time.Since(inst.lastUsed) >= idle {
+			delete(m.instances, id)
+			evicted = append(evicted, inst.fsm)
+		}
+	}
+	m.mu.Unlock()
+
+	for _, fsm := range evicted {
+		fsm.Stop()
+	}
+	return len(evicted)
+}
+
+// Len returns number of loaded instances.
+func (m *Manager) Len() int {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return len(m.instances)
+}
+
+// Dispatch dispatch transition of the instance by ID and wait for completion.
+func (m *Manager) Dispatch(ctx context.Context, id, next string) error {
+	inst, err := m.acquire(ctx, id)
+	if err != nil {
+		return err
+	}
+	err = inst.fsm.Dispatch(ctx, next)
+	m.release(inst, err)
+	return err
+}
+
+// DispatchEvent dispatch the event of the instance by ID and wait for completion.
+func (m *Manager) DispatchEvent(ctx context.Context, id, event string) error {
+	inst, err := m.acquire(ctx, id)
+	if err != nil {
+		return err
+	}
+	err = inst.fsm.DispatchEvent(ctx, event)
+	m.release(inst, err)
+	return err
+}
+
+// State returns current state of the instance by ID.
+func (m *Manager) State(ctx context.Context, id string) (string, error) {
+	inst, err := m.acquire(ctx, id)
+	if err != nil {
+		return UnknownState, err
+	}
+	defer m.release(inst, nil)
+	return inst.fsm.State(), nil
+}
+
+// Stop stops eviction and dispatchers of all instances. Dispatch after Stop
+// fails with ErrManagerStopped, the instance with pending dispatches is
+// stopped when all of them are released.
+func (m *Manager) Stop() {
+	m.SetIdleTimeout(0)
+	m.wg.Wait()
+
+	var stopped []*FSM
+	m.mu.Lock()
+	m.stopped = true
+	for _, inst := range m.instances {
+		inst.stale = true
+		if inst.refs == 0 && inst.fsm != nil {
+			stopped = append(stopped, inst.fsm)
+		}
+	}
+	m.instances = map[string]*instance{}
+	m.mu.Unlock()
+
+	for _, fsm := range stopped {
+		fsm.Stop()
+	}
+}
+
+// acquire returns the instance by ID (loads it if it is not loaded) and
+// protects it from eviction until release.
+func (m *Manager) acquire(ctx context.Context, id string) (*instance, error) {
+	m.mu.Lock()
+	if m.stopped {
+		m.mu.Unlock()
+		return nil, ErrManagerStopped
+	}
+	inst, ok := m.instances[id]
+	if !ok {
+		inst = &instance{id: id, ready: make(chan struct{})}
+		m.instances[id] = inst
+	}
+	inst.refs++
+	onCreate := m.onCreate
+	engine := m.engine
+	journal := m.journal
+	m.mu.Unlock()
+
+	if !ok {
+		if engine != nil {
+			inst.fsm, inst.err = engine.NewFSMWithStore(ctx, m.wf, m.initState, m.store, id)
+		} else {
+			inst.fsm, inst.err = NewFSMWithStore(ctx, m.wf, m.initState, m.store, id)
+		}
+		if inst.err == nil && journal != nil {
+			if inst.err = inst.fsm.AttachJournal(ctx, journal, id); inst.err != nil {
+				inst.fsm.Stop()
+				inst.fsm = nil
+			}
+		}
+		if inst.err == nil && onCreate != nil {
+			onCreate(id, inst.fsm)
+		}
+		close(inst.ready)
+	}
+	<-inst.ready
+
+	if inst.err != nil {
+		m.mu.Lock()
+		if m.instances[id] == inst {
+			delete(m.instances, id)
+		}
+		m.mu.Unlock()
+		return nil, inst.err
+	}
+	return inst, nil
+}
+
+// release releases the instance after the dispatch with the error. The
+// instance with stale state (ErrStateConflict) is evicted and it is stopped
+// when all pending dispatches are released.
+func (m *Manager) release(inst *instance, err error) {
+	m.mu.Lock()
+	inst.refs--
+	inst.lastUsed = time.Now()
+	if errors.Is(err, ErrStateConflict) && !inst.stale {
+		inst.stale = true
+		if m.instances[inst.id] == inst {
+			delete(m.instances, inst.id)
+		}
+	}
+	stop := inst.stale && inst.refs == 0
+	m.mu.Unlock()
+
+	if stop {
+		inst.fsm.Stop()
+	}
+}
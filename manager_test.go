@@ -0,0 +1,190 @@
+package ffsm
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestManager(t *testing.T) {
+	ctx := context.Background()
+	wf := make(Stack).
+		Add(CloseDoor, OpenDoor).
+		Add(OpenDoor, CloseDoor).
+		AddEvent(OpenDoor, "knock", TokTokDoor)
+	store := NewMemoryStore()
+	m := NewManager(wf, CloseDoor, store)
+	defer m.Stop()
+
+	var created []string
+	m.OnCreate(func(id string, fsm *FSM) {
+		created = append(created, id)
+	})
+
+	require.NoError(t, m.Dispatch(ctx, "door/1", OpenDoor))
+	require.NoError(t, m.Dispatch(ctx, "door/2", OpenDoor))
+	require.NoError(t, m.Dispatch(ctx, "door/2", CloseDoor))
+	assert.Equal(t, 2, m.Len())
+	assert.Equal(t, []string{"door/1", "door/2"}, created)
+
+	state, err := m.State(ctx, "door/1")
+	require.NoError(t, err)
+	assert.Equal(t, OpenDoor, state)
+
+	assert.Equal(t, 2, m.Evict(0))
+	assert.Equal(t, 0, m.Len())
+
+	// loaded from store
+	require.NoError(t, m.DispatchEvent(ctx, "door/1", "knock"))
+	state, err = m.State(ctx, "door/2")
+	require.NoError(t, err)
+	assert.Equal(t, CloseDoor, state)
+	assert.Equal(t, 0, m.Evict(time.Hour))
+	assert.Equal(t, []string{"door/1", "door/2", "door/1", "door/2"}, created)
+
+	record, err := store.Load(ctx, "door/1")
+	require.NoError(t, err)
+	assert.Equal(t, TokTokDoor, record.State)
+
+	err = m.Dispatch(ctx, "door/3", TokTokDoor)
+	assert.True(t, errors.Is(err, ErrNotRegTransition))
+}
+
+func TestManager_Conflict(t *testing.T) {
+	ctx := context.Background()
+	wf := make(Stack).
+		Add(CloseDoor, OpenDoor).
+		Add(OpenDoor, CloseDoor)
+	store := NewMemoryStore()
+	m1 := NewManager(wf, CloseDoor, store)
+	defer m1.Stop()
+	m2 := NewManager(wf, CloseDoor, store)
+	defer m2.Stop()
+
+	state, err := m2.State(ctx, "door/1")
+	require.NoError(t, err)
+	assert.Equal(t, CloseDoor, state)
+	require.NoError(t, m1.Dispatch(ctx, "door/1", OpenDoor))
+
+	// the instance of m2 is stale, it is evicted after the conflict
+	err = m2.Dispatch(ctx, "door/1", OpenDoor)
+	assert.True(t, errors.Is(err, ErrStateConflict))
+	assert.Equal(t, 0, m2.Len())
+
+	// and the state is reloaded by the next dispatch
+	require.NoError(t, m2.Dispatch(ctx, "door/1", CloseDoor))
+	record, err := store.Load(ctx, "door/1")
+	require.NoError(t, err)
+	assert.Equal(t, CloseDoor, record.State)
+	assert.EqualValues(t, 2, record.Version)
+}
+
+type brokenStore struct {
+	Store
+}
+
+func (s brokenStore) Load(ctx context.Context, id string) (Record, error) {
+	return Record{}, errors.New("store is unavailable")
+}
+
+func TestManager_LoadFailed(t *testing.T) {
+	m := NewManager(make(Stack), CloseDoor, brokenStore{})
+	defer m.Stop()
+	assert.EqualError(t, m.Dispatch(context.Background(), "door", OpenDoor), "store is unavailable")
+	assert.Equal(t, 0, m.Len())
+}
+
+func TestManager_IdleTimeout(t *testing.T) {
+	m := NewManager(make(Stack).Add(CloseDoor, OpenDoor).Add(OpenDoor, CloseDoor), CloseDoor, nil)
+	defer m.Stop()
+	m.SetIdleTimeout(20 * time.Millisecond)
+
+	require.NoError(t, m.Dispatch(context.Background(), "door", OpenDoor))
+	assert.Equal(t, 1, m.Len())
+	time.Sleep(100 * time.Millisecond)
+	assert.Equal(t, 0, m.Len())
+
+	state, err := m.State(context.Background(), "door")
+	require.NoError(t, err)
+	assert.Equal(t, OpenDoor, state)
+}
+
+func TestManager_ConcurrentDispatch(t *testing.T) {
+	var mu sync.Mutex
+	order := map[string][]string{}
+	wf := make(Stack).
+		Add(AnyState, AnyState, func(ctx context.Context) (context.Context, error) {
+			mu.Lock()
+			id := ctx.Value("__id").(string)
+			order[id] = append(order[id], GetDstState(ctx))
+			mu.Unlock()
+			return ctx, nil
+		})
+	m := NewManager(wf, "0", nil)
+	defer m.Stop()
+
+	wg := sync.WaitGroup{}
+	for i := 0; i < 10; i++ {
+		id := fmt.Sprint("id", i)
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			ctx := context.WithValue(context.Background(), "__id", id)
+			for n := 1; n <= 50; n++ {
+				if err := m.Dispatch(ctx, id, fmt.Sprint(n)); err != nil {
+					t.Error(err)
+				}
+				if n%10 == 0 {
+					m.Evict(0)
+				}
+			}
+		}()
+	}
+	wg.Wait()
+
+	for i := 0; i < 10; i++ {
+		id := fmt.Sprint("id", i)
+		require.Len(t, order[id], 50)
+		for n := 1; n <= 50; n++ {
+			assert.Equal(t, fmt.Sprint(n), order[id][n-1])
+		}
+	}
+}
+
+func TestManager_StopConcurrentDispatch(t *testing.T) {
+	wf := make(Stack).Add(AnyState, AnyState, door{}.Empty)
+	m := NewManager(wf, "0", nil)
+
+	wg := sync.WaitGroup{}
+	for i := 0; i < 10; i++ {
+		id := fmt.Sprint("id", i%3)
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for n := 1; n <= 50; n++ {
+				err := m.Dispatch(context.Background(), id, fmt.Sprint(n))
+				if errors.Is(err, ErrManagerStopped) {
+					return
+				}
+				if err != nil {
+					t.Error(err)
+				}
+			}
+		}()
+	}
+	time.Sleep(time.Millisecond)
+	m.Stop()
+	wg.Wait()
+
+	assert.Equal(t, 0, m.Len())
+	err := m.Dispatch(context.Background(), "id0", "1")
+	assert.True(t, errors.Is(err, ErrManagerStopped))
+	_, err = m.State(context.Background(), "id0")
+	assert.True(t, errors.Is(err, ErrManagerStopped))
+}
@@ -0,0 +1,97 @@
+package ffsm
+
+import (
+	"context"
+	"sort"
+)
+
+// AvailableTransitionOf is the transition available from the state (see
+// StackOf.Available).
+type AvailableTransitionOf[S comparable] struct {
+	Dst   S             // destination state
+	Event string        // name of the event (empty for the transition by the destination state)
+	Key   StackKeyOf[S] // resolved key of Stack (Src or Dst can be AnyState)
+}
+
+// AvailableTransition is the transition available from the state of Stack.
+type AvailableTransition = AvailableTransitionOf[string]
+
+// Available returns transitions available from the state: destination states
+// and events of Stack resolved as by Resolve (with parent states and
+// wildcards), sorted by event and name of destination state. Only
+// destination states registered in Stack are listed (transition to AnyState
+// allows any state).
+//
+// Guards are not executed, see FSM.CanDispatch.
+func (r StackOf[S, P]) Available(src S) []AvailableTransitionOf[S] {
+	if r == nil {
+		panic("Stack.Available: stack is empty")
+	}
+	var unknown S
+	if src == unknown {
+		return nil
+	}
+	var list []AvailableTransitionOf[S]
+	seen := map[StackKeyOf[S]]bool{}
+	for k := range r {
+		if k.Kind != TransitionKind {
+			continue
+		}
+		candidate := StackKeyOf[S]{Dst: k.Dst, Event: k.Event}
+		if k.Event != "" {
+			candidate.Dst = unknown
+		}
+		if isAnyState(candidate.Dst) || seen[candidate] {
+			continue
+		}
+		seen[candidate] = true
+
+		key, ok := r.Resolve(src, candidate.Dst, candidate.Event)
+		if !ok {
+			continue
+		}
+		dst := candidate.Dst
+		if k.Event != "" {
+			dst = key.Dst
+		}
+		list = append(list, AvailableTransitionOf[S]{Dst: dst, Event: k.Event, Key: key})
+	}
+	sort.Slice(list, func(i, j int) bool {
+		if list[i].Event != list[j].Event {
+			return list[i].Event < list[j].Event
+		}
+		return StateName(list[i].Dst) < StateName(list[j].Dst)
+	})
+	return list
+}
+
+// AvailableTransitions returns transitions available from the current state
+// of FSM (see Stack.Available). Guards are not executed.
+func (e *FSMOf[S, P]) AvailableTransitions() []AvailableTransitionOf[S] {
+	r := e.region(MainRegion)
+	return r.wf.Available(e.regionState(r))
+}
+
+// CanDispatch checks the transition to the next state without side effects:
+// only guards of the transition are executed (actions, hooks, journal and
+// store are skipped and the state is not changed). Returns nil if the
+// transition is registered and allowed by guards, otherwise DispatchError as
+// Dispatch.
+//
+// The check is queued as the dispatch, so it is executed after the pending
+// transitions.
+func (e *FSMOf[S, P]) CanDispatch(ctx context.Context, next S) error {
+	return e.check(e.newMessage(ctx, MainRegion, next, ""))
+}
+
+// CanDispatchEvent checks the transition by the event without side effects
+// (see CanDispatch).
+func (e *FSMOf[S, P]) CanDispatchEvent(ctx context.Context, event string) error {
+	var unknown S
+	return e.check(e.newMessage(ctx, MainRegion, unknown, event))
+}
+
+func (e *FSMOf[S, P]) check(m *message[S, P]) error {
+	m.dryRun = true
+	return <-e.enqueue(m).done
+}
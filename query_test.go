@@ -0,0 +1,127 @@
+package ffsm
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_Stack_Available(t *testing.T) {
+	wf := make(Stack).
+		Add(CloseDoor, OpenDoor).
+		Add("active", "done").
+		Add("active.picking", "active.packing").
+		Add(AnyState, "broken").
+		Add("broken", AnyState).
+		AddEvent("active", "cancel", "done").
+		AddEvent("active.picking", "cancel", CloseDoor). // beats the event of the parent
+		AddEvent(AnyState, "reset", CloseDoor)
+
+	assert.Equal(t, []AvailableTransition{
+		{Dst: "active.packing", Key: StackKey{Src: "active.picking", Dst: "active.packing"}},
+		{Dst: "broken", Key: StackKey{Src: AnyState, Dst: "broken"}},
+		{Dst: "done", Key: StackKey{Src: "active", Dst: "done"}},
+		{Dst: CloseDoor, Event: "cancel", Key: StackKey{Src: "active.picking", Dst: CloseDoor, Event: "cancel"}},
+		{Dst: CloseDoor, Event: "reset", Key: StackKey{Src: AnyState, Dst: CloseDoor, Event: "reset"}},
+	}, wf.Available("active.picking"))
+
+	// transitions to any state are listed only for registered destination states
+	var dst []string
+	for _, a := range wf.Available("broken") {
+		dst = append(dst, a.Dst+"/"+a.Event)
+	}
+	assert.Equal(t, []string{"active.packing/", "broken/", "done/", "open/", "close/reset"}, dst)
+
+	assert.Nil(t, wf.Available(UnknownState))
+}
+
+func Test_FSM_CanDispatch(t *testing.T) {
+	door := &door{}
+	var calls []string
+	trace := func(name string) Procedure {
+		return func(ctx context.Context) (context.Context, error) {
+			calls = append(calls, name)
+			return ctx, nil
+		}
+	}
+	wf := make(Stack).
+		AddGuard(CloseDoor, OpenDoor, door.GuardOnlyBob).
+		Add(CloseDoor, OpenDoor, trace("open")).
+		OnExit(CloseDoor, trace("exit")).
+		Add(OpenDoor, CloseDoor, func(ctx context.Context) (context.Context, error) {
+			time.Sleep(20 * time.Millisecond)
+			return ctx, nil
+		}).
+		AddEventGuard(OpenDoor, "knock", TokTokDoor, door.GuardOnlyBob)
+	store := NewMemoryStore()
+	fsm, err := NewFSMWithStore(context.Background(), wf, CloseDoor, store, "1")
+	require.NoError(t, err)
+	defer fsm.Stop()
+	assert.Equal(t, []AvailableTransition{
+		{Dst: OpenDoor, Key: StackKey{Src: CloseDoor, Dst: OpenDoor}},
+	}, fsm.AvailableTransitions())
+
+	bobCtx := context.WithValue(context.Background(), "__name", "bob")
+	err = fsm.CanDispatch(context.Background(), OpenDoor)
+	assert.True(t, errors.Is(err, ErrGuardRejected))
+	assert.NoError(t, fsm.CanDispatch(bobCtx, OpenDoor))
+	assert.True(t, errors.Is(fsm.CanDispatch(bobCtx, TokTokDoor), ErrNotRegTransition))
+	assert.Empty(t, calls)
+	assert.Equal(t, CloseDoor, fsm.State())
+	_, err = store.Load(context.Background(), "1")
+	assert.True(t, errors.Is(err, ErrStateNotFound))
+
+	require.NoError(t, fsm.Dispatch(bobCtx, OpenDoor))
+	assert.Equal(t, []string{"exit", "open"}, calls)
+
+	// the check is executed after pending transitions
+	done, _ := fsm.AsyncDispatch(context.Background(), CloseDoor)
+	err = fsm.CanDispatchEvent(bobCtx, "knock")
+	assert.True(t, errors.Is(err, ErrNotRegTransition))
+	require.NoError(t, <-done)
+	assert.Equal(t, CloseDoor, fsm.State())
+	assert.Equal(t, []AvailableTransition{
+		{Dst: OpenDoor, Key: StackKey{Src: CloseDoor, Dst: OpenDoor}},
+	}, fsm.AvailableTransitions())
+
+	require.NoError(t, fsm.Dispatch(bobCtx, OpenDoor))
+	assert.NoError(t, fsm.CanDispatchEvent(bobCtx, "knock"))
+	assert.Equal(t, OpenDoor, fsm.State())
+}
+
+func Test_FSM_CanDispatch_Metrics(t *testing.T) {
+	door := &door{}
+	wf := make(Stack).
+		AddGuard(CloseDoor, OpenDoor, door.GuardOnlyBob).
+		Add(CloseDoor, OpenDoor, door.Empty)
+	fsm := NewFSM(wf, CloseDoor)
+	defer fsm.Stop()
+	var infos []ActionInfo
+	fsm.Use(func(ctx context.Context, info ActionInfo, next Procedure) (context.Context, error) {
+		infos = append(infos, info)
+		return next(ctx)
+	})
+
+	bobCtx := context.WithValue(context.Background(), "__name", "bob")
+	require.NoError(t, fsm.CanDispatch(bobCtx, OpenDoor))
+	require.Len(t, infos, 1)
+	assert.True(t, infos[0].DryRun)
+	reg := prometheus.NewRegistry()
+	require.NoError(t, reg.Register(fsm))
+	metrics, err := reg.Gather()
+	require.NoError(t, err)
+	for _, m := range metrics {
+		assert.Equal(t, "ffsm_ffsm_region_state", m.GetName())
+	}
+
+	require.NoError(t, fsm.Dispatch(bobCtx, OpenDoor))
+	require.Len(t, infos, 3)
+	assert.False(t, infos[1].DryRun)
+	assert.EqualValues(t, 1, testutil.ToFloat64(fsm.mTotalRequest.WithLabelValues(fsm.name)))
+}
@@ -0,0 +1,121 @@
+package ffsm
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// MainRegion is the name of the region of the main state of FSM
+// (the state returned by FSM.State).
+const MainRegion = ""
+
+// region is the orthogonal region of FSM with own transitions and current state.
+type region[S comparable, P any] struct {
+	name  string
+	wf    StackOf[S, P]
+	state S
+}
+
+var regionStateDesc = prometheus.NewDesc(
+	prometheus.BuildFQName("", "ffsm", "ffsm_region_state"),
+	"Current state of the region (always 1).",
+	[]string{"ffsm", "region", "state"},
+	nil,
+)
+
+// AddRegion adds the parallel region with own transitions and initial state.
+// The region changes its state independently of the main state and other
+// regions: DispatchEvent dispatches the event to all regions that accept it
+// (main region first, then regions in order of adding), DispatchRegion
+// dispatches transition of the single region.
+//
+// The transition of each region is committed (journal, store and state)
+// before the next region is dispatched, so the failure of the region does not
+// revert transitions of previous regions: DispatchError.Committed has names of
+// regions changed by the failed event.
+//
+// If FSM has the journal (see Replay and AttachJournal) the region starts
+// with the state restored from the journal.
+func (e *FSMOf[S, P]) AddRegion(name string, wf StackOf[S, P], initState S) {
+	if name == MainRegion {
+		panic("FSM.AddRegion: name of region is empty")
+	}
+	e.stateMutex.Lock()
+	defer e.stateMutex.Unlock()
+	for _, r := range e.regions {
+		if r.name == name {
+			panic(fmt.Sprintf("FSM.AddRegion: region %q already exists", name))
+		}
+	}
+	state := initState
+	if restored, ok := e.restored[name]; ok {
+		state = restored
+	}
+	e.regions = append(e.regions, &region[S, P]{name: name, wf: wf, state: wf.initial(state)})
+}
+
+// RegionState returns current state of the region (UnknownState if the
+// region does not exist).
+func (e *FSMOf[S, P]) RegionState(name string) S {
+	r := e.region(name)
+	if r == nil {
+		var unknown S
+		return unknown
+	}
+	return e.regionState(r)
+}
+
+// Configuration returns current states of all regions by name of region
+// (including MainRegion).
+func (e *FSMOf[S, P]) Configuration() map[string]S {
+	e.stateMutex.RLock()
+	defer e.stateMutex.RUnlock()
+	conf := make(map[string]S, len(e.regions))
+	for _, r := range e.regions {
+		conf[r.name] = r.state
+	}
+	return conf
+}
+
+// AsyncDispatchRegion dispatcher of the transition of the region (thread-safe).
+// Returns the channel for feedback and the function of cancel of transition context.
+func (e *FSMOf[S, P]) AsyncDispatchRegion(ctx context.Context, region string, next S) (chan error, context.CancelFunc) {
+	return e.asyncDispatch(ctx, region, next, "")
+}
+
+// DispatchRegion dispatch the transition of the region and wait for completion.
+func (e *FSMOf[S, P]) DispatchRegion(ctx context.Context, region string, next S) error {
+	done, _ := e.AsyncDispatchRegion(ctx, region, next)
+	return <-done
+}
+
+func (e *FSMOf[S, P]) region(name string) *region[S, P] {
+	e.stateMutex.RLock()
+	defer e.stateMutex.RUnlock()
+	for _, r := range e.regions {
+		if r.name == name {
+			return r
+		}
+	}
+	return nil
+}
+
+func (e *FSMOf[S, P]) regionList() []*region[S, P] {
+	e.stateMutex.RLock()
+	defer e.stateMutex.RUnlock()
+	return append([]*region[S, P](nil), e.regions...)
+}
+
+func (e *FSMOf[S, P]) regionState(r *region[S, P]) S {
+	e.stateMutex.RLock()
+	defer e.stateMutex.RUnlock()
+	return r.state
+}
+
+func (e *FSMOf[S, P]) setRegionState(r *region[S, P], state S) {
+	e.stateMutex.Lock()
+	r.state = state
+	e.stateMutex.Unlock()
+}
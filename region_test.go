@@ -0,0 +1,115 @@
+package ffsm
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_FSM_Regions(t *testing.T) {
+	order := make(Stack).
+		AddEvent("new", "pay", "processing").
+		AddEvent("processing", "ship", "done")
+	payment := make(Stack).
+		AddEvent("unpaid", "pay", "paid", func(ctx context.Context) (context.Context, error) {
+			assert.Equal(t, "payment", GetRegion(ctx))
+			return ctx, nil
+		}).
+		Add("paid", "refunded")
+	shipping := make(Stack).
+		AddEvent("pending", "ship", "shipped")
+
+	fsm := NewFSM(order, "new")
+	fsm.AddRegion("payment", payment, "unpaid")
+	fsm.AddRegion("shipping", shipping, "pending")
+	assert.Panics(t, func() {
+		fsm.AddRegion("payment", payment, "unpaid")
+	})
+
+	assert.Equal(t, map[string]string{
+		MainRegion: "new",
+		"payment":  "unpaid",
+		"shipping": "pending",
+	}, fsm.Configuration())
+
+	// accepted by main region and payment region
+	assert.NoError(t, fsm.DispatchEvent(context.Background(), "pay"))
+	assert.Equal(t, "processing", fsm.State())
+	assert.Equal(t, "paid", fsm.RegionState("payment"))
+	assert.Equal(t, "pending", fsm.RegionState("shipping"))
+
+	// accepted only by payment region
+	err := fsm.DispatchEvent(context.Background(), "pay")
+	assert.True(t, errors.Is(err, ErrNotRegTransition))
+
+	assert.NoError(t, fsm.DispatchEvent(context.Background(), "ship"))
+	assert.Equal(t, "done", fsm.State())
+	assert.Equal(t, "shipped", fsm.RegionState("shipping"))
+
+	assert.NoError(t, fsm.DispatchRegion(context.Background(), "payment", "refunded"))
+	assert.Equal(t, "refunded", fsm.RegionState("payment"))
+
+	err = fsm.DispatchRegion(context.Background(), "not exists", "refunded")
+	assert.True(t, errors.Is(err, ErrNotRegRegion))
+	assert.Equal(t, UnknownState, fsm.RegionState("not exists"))
+
+	reg := prometheus.NewRegistry()
+	require.NoError(t, reg.Register(fsm))
+	families, err := reg.Gather()
+	require.NoError(t, err)
+	states := map[string]string{}
+	for _, f := range families {
+		if f.GetName() != "ffsm_ffsm_region_state" {
+			continue
+		}
+		for _, m := range f.GetMetric() {
+			labels := map[string]string{}
+			for _, l := range m.GetLabel() {
+				labels[l.GetName()] = l.GetValue()
+			}
+			states[labels["region"]] = labels["state"]
+		}
+	}
+	assert.Equal(t, fsm.Configuration(), states)
+}
+
+func Test_FSM_Regions_Committed(t *testing.T) {
+	errDeclined := errors.New("declined")
+	order := make(Stack).AddEvent("new", "pay", "processing")
+	payment := make(Stack).AddEvent("unpaid", "pay", "paid")
+	card := make(Stack).AddEvent("active", "pay", "charged", func(ctx context.Context) (context.Context, error) {
+		return ctx, errDeclined
+	})
+
+	fsm := NewFSM(order, "new")
+	defer fsm.Stop()
+	fsm.AddRegion("payment", payment, "unpaid")
+	fsm.AddRegion("card", card, "active")
+
+	// transitions of previous regions are not reverted
+	err := fsm.DispatchEvent(context.Background(), "pay")
+	assert.True(t, errors.Is(err, errDeclined))
+	var dispatchErr DispatchError
+	require.True(t, errors.As(err, &dispatchErr))
+	assert.Equal(t, "card", dispatchErr.Region)
+	assert.Equal(t, []string{MainRegion, "payment"}, dispatchErr.Committed)
+	assert.Equal(t, map[string]string{
+		MainRegion: "processing",
+		"payment":  "paid",
+		"card":     "active",
+	}, fsm.Configuration())
+
+	// regions after the failed region are not dispatched
+	fsm = NewFSM(order, "new")
+	defer fsm.Stop()
+	fsm.AddRegion("card", card, "active")
+	fsm.AddRegion("payment", payment, "unpaid")
+	err = fsm.DispatchEvent(context.Background(), "pay")
+	require.True(t, errors.As(err, &dispatchErr))
+	assert.Equal(t, []string{MainRegion}, dispatchErr.Committed)
+	assert.Equal(t, "unpaid", fsm.RegionState("payment"))
+}
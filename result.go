@@ -0,0 +1,119 @@
+package ffsm
+
+import (
+	"context"
+	"errors"
+	"time"
+)
+
+// DispatchResultOf is the result of the dispatch of FSMOf with states of type
+// S and the payload of type P returned by FSM.DispatchResult and
+// FSM.AsyncDispatchResult.
+//
+// For the event accepted by several regions Region, SrcState and DstState are
+// of the last executed transition, Actions are of all transitions.
+type DispatchResultOf[S comparable, P any] struct {
+	Region   string
+	SrcState S // state before the dispatch
+	DstState S // destination state of the transition (even if it failed)
+	Event    string
+
+	// Actions executed procedures (guards, hooks and actions without
+	// compensations) in order of execution.
+	Actions []ActionResult
+
+	// FailedIndex index of the failed action in Actions (-1 if the dispatch
+	// is successful or failed not by the action).
+	FailedIndex int
+	// FailedAction name of the failed action (see DispatchError.ActionName),
+	// empty if the dispatch is successful or failed not by the action.
+	FailedAction string
+
+	// Context returned by the last procedure of the transition and the
+	// payload of the transition (see FSM.DispatchPayload), zero values if
+	// failed.
+	Context context.Context
+	Payload P
+
+	QueueWait time.Duration // time in the queue of the dispatcher
+	Duration  time.Duration // duration of the dispatch (without QueueWait)
+
+	Err error
+}
+
+// DispatchResult is the result of the dispatch of FSM.
+type DispatchResult = DispatchResultOf[string, interface{}]
+
+// ActionResult is the result of the executed procedure.
+type ActionResult struct {
+	ActionInfo
+	Duration time.Duration // with all attempts
+	Attempts int
+	Err      error
+}
+
+// DispatchResult dispatch and wait for completion. Returns the result of the
+// dispatch with the error of it.
+func (e *FSMOf[S, P]) DispatchResult(ctx context.Context, next S) (DispatchResultOf[S, P], error) {
+	results, _ := e.AsyncDispatchResult(ctx, next)
+	res := <-results
+	return res, res.Err
+}
+
+// DispatchEventResult dispatch the event and wait for completion. Returns the
+// result of the dispatch with the error of it.
+func (e *FSMOf[S, P]) DispatchEventResult(ctx context.Context, event string) (DispatchResultOf[S, P], error) {
+	var unknown S
+	results, _ := e.asyncDispatchResult(ctx, MainRegion, unknown, event)
+	res := <-results
+	return res, res.Err
+}
+
+// AsyncDispatchResult dispatcher of finite state machine (thread-safe).
+// Returns the channel of the result and the function of cancel of transition
+// context.
+func (e *FSMOf[S, P]) AsyncDispatchResult(ctx context.Context, next S) (chan DispatchResultOf[S, P], context.CancelFunc) {
+	return e.asyncDispatchResult(ctx, MainRegion, next, "")
+}
+
+func (e *FSMOf[S, P]) asyncDispatchResult(ctx context.Context, region string, next S, event string) (chan DispatchResultOf[S, P], context.CancelFunc) {
+	ctx, cancel := context.WithCancel(ctx)
+	msg := e.newMessage(ctx, region, next, event)
+	msg.result = &DispatchResultOf[S, P]{}
+	msg.results = make(chan DispatchResultOf[S, P], 1)
+	e.enqueue(msg)
+	return msg.results, cancel
+}
+
+// beginResult sets the result of the message before the dispatch.
+func (e *FSMOf[S, P]) beginResult(m *message[S, P], start time.Time) {
+	r := m.result
+	r.Region = m.region
+	r.SrcState = e.State()
+	if reg := e.region(m.region); reg != nil {
+		r.SrcState = e.regionState(reg)
+	}
+	r.DstState = m.next
+	r.Event = m.event
+	r.FailedIndex = -1
+	r.QueueWait = start.Sub(m.enqueued)
+}
+
+// endResult sets the result of the message after the dispatch.
+func (m *message[S, P]) endResult(start time.Time, err error) {
+	r := m.result
+	r.Duration = time.Since(start)
+	r.Err = err
+	if err == nil {
+		r.Context = m.final
+		r.Payload = m.payload
+		return
+	}
+	if n := len(r.Actions); n > 0 && r.Actions[n-1].Err != nil {
+		r.FailedIndex = n - 1
+	}
+	var dispatchErr DispatchError
+	if errors.As(err, &dispatchErr) {
+		r.FailedAction = dispatchErr.ActionName
+	}
+}
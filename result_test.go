@@ -0,0 +1,117 @@
+package ffsm
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_FSM_DispatchResult(t *testing.T) {
+	door := &door{}
+	slow := func(ctx context.Context, payload interface{}) (interface{}, error) {
+		time.Sleep(10 * time.Millisecond)
+		return "opened", nil
+	}
+	wf := make(Stack).
+		AddGuard(CloseDoor, OpenDoor, door.GuardOnlyBob).
+		AddAction(CloseDoor, OpenDoor, Action{Name: "slow", PayloadProcedure: slow}).
+		OnEnter(OpenDoor, door.Empty).
+		AddAction(OpenDoor, CloseDoor, Action{Name: "abort", Procedure: door.AbortOpen})
+	fsm := NewFSM(wf, CloseDoor)
+	defer fsm.Stop()
+
+	ctx := context.WithValue(context.Background(), "__name", "bob")
+	res, err := fsm.DispatchResult(ctx, OpenDoor)
+	require.NoError(t, err)
+	assert.Equal(t, MainRegion, res.Region)
+	assert.Equal(t, CloseDoor, res.SrcState)
+	assert.Equal(t, OpenDoor, res.DstState)
+	assert.Equal(t, -1, res.FailedIndex)
+	assert.Empty(t, res.FailedAction)
+	assert.Equal(t, "opened", res.Payload)
+	assert.NotNil(t, res.Context)
+	require.Len(t, res.Actions, 3)
+	assert.True(t, res.Actions[0].Guard)
+	assert.Equal(t, "slow", res.Actions[1].Name)
+	assert.Equal(t, EnterKind, res.Actions[2].Kind)
+	assert.True(t, res.Actions[1].Duration >= 10*time.Millisecond)
+	assert.Equal(t, 1, res.Actions[1].Attempts)
+	assert.True(t, res.Duration >= res.Actions[1].Duration)
+
+	results, _ := fsm.AsyncDispatchResult(context.Background(), CloseDoor)
+	res = <-results
+	assert.EqualError(t, res.Err, `abort open door ("open"=>"close" #0)`)
+	assert.Equal(t, OpenDoor, res.SrcState)
+	assert.Equal(t, CloseDoor, res.DstState)
+	assert.Equal(t, 0, res.FailedIndex)
+	assert.Equal(t, "abort", res.FailedAction)
+	assert.Nil(t, res.Context)
+	assert.Equal(t, OpenDoor, fsm.State())
+
+	res, err = fsm.DispatchEventResult(context.Background(), "knock")
+	assert.True(t, errors.Is(err, ErrNotRegTransition))
+	assert.Equal(t, OpenDoor, res.SrcState)
+	assert.Equal(t, "knock", res.Event)
+	assert.Equal(t, -1, res.FailedIndex)
+	assert.Empty(t, res.Actions)
+}
+
+func Test_FSM_DispatchResult_QueueWait(t *testing.T) {
+	wf := make(Stack).
+		Add(CloseDoor, OpenDoor, func(ctx context.Context) (context.Context, error) {
+			time.Sleep(20 * time.Millisecond)
+			return ctx, nil
+		}).
+		Add(OpenDoor, CloseDoor)
+	fsm := NewFSM(wf, CloseDoor)
+	defer fsm.Stop()
+
+	done, _ := fsm.AsyncDispatch(context.Background(), OpenDoor)
+	res, err := fsm.DispatchResult(context.Background(), CloseDoor)
+	require.NoError(t, <-done)
+	require.NoError(t, err)
+	assert.True(t, res.QueueWait >= 10*time.Millisecond, res.QueueWait)
+	assert.True(t, res.Duration < res.QueueWait)
+}
+
+func Test_FSM_DispatchResult_Store(t *testing.T) {
+	wf := make(Stack).Add(CloseDoor, OpenDoor, (door{}).Empty)
+	fsm, err := NewFSMWithStore(context.Background(), wf, CloseDoor, failedStore{NewMemoryStore()}, "1")
+	require.NoError(t, err)
+	defer fsm.Stop()
+
+	res, err := fsm.DispatchResult(context.Background(), OpenDoor)
+	assert.EqualError(t, err, `store is unavailable ("close"=>"open")`)
+	assert.Len(t, res.Actions, 1)
+	assert.Equal(t, -1, res.FailedIndex)
+	assert.Equal(t, StoreActionName, res.FailedAction)
+}
+
+func Test_FSMOf_DispatchResult(t *testing.T) {
+	wf := make(StackOf[gate, int]).
+		AddPayload(gateClosed, gateOpened, func(ctx context.Context, opens int) (int, error) {
+			return opens + 1, nil
+		}).
+		AddGuard(gateOpened, gateBroken, func(ctx context.Context) error {
+			return errors.New("locked")
+		})
+	fsm := NewFSMOf(wf, gateClosed)
+	defer fsm.Stop()
+
+	res, err := fsm.DispatchResult(context.Background(), gateOpened)
+	require.NoError(t, err)
+	assert.Equal(t, gateClosed, res.SrcState)
+	assert.Equal(t, gateOpened, res.DstState)
+	assert.Equal(t, 1, res.Payload)
+
+	res, err = fsm.DispatchResult(context.Background(), gateBroken)
+	assert.True(t, errors.Is(err, ErrGuardRejected))
+	assert.Equal(t, gateOpened, res.SrcState)
+	assert.Equal(t, gateBroken, res.DstState)
+	assert.Equal(t, 0, res.FailedIndex)
+	assert.Equal(t, 0, res.Payload)
+}
@@ -0,0 +1,80 @@
+package ffsm
+
+import (
+	"context"
+	"math"
+	"math/rand"
+	"time"
+)
+
+// RetryPolicy is the policy of retries of the failed procedure.
+type RetryPolicy struct {
+	// MaxAttempts maximum number of attempts including the first one.
+	MaxAttempts int
+
+	// Backoff delay before the second attempt. Delay of each next attempt
+	// is multiplied by Multiplier (by 2 if it is zero) up to MaxBackoff.
+	Backoff    time.Duration
+	MaxBackoff time.Duration
+	Multiplier float64
+
+	// Jitter fraction of the delay in range [0, 1] which is randomized (it is
+	// clamped to the range). For example delay 100ms with jitter 0.2 is random
+	// in range [80ms, 120ms].
+	Jitter float64
+
+	// Retryable reports whether the error is retryable. If it is nil all
+	// errors except panics are retryable.
+	Retryable func(err error) bool
+}
+
+// Delay returns delay before the next attempt after the attempt (starts with 1).
+// Delay is not greater than the maximum duration (math.MaxInt64) even without
+// MaxBackoff.
+func (p RetryPolicy) Delay(attempt int) time.Duration {
+	multiplier := p.Multiplier
+	if multiplier == 0 {
+		multiplier = 2
+	}
+	max := float64(math.MaxInt64)
+	if p.MaxBackoff > 0 {
+		max = float64(p.MaxBackoff)
+	}
+	delay := float64(p.Backoff)
+	for i := 1; i < attempt && delay < max; i++ {
+		delay *= multiplier
+	}
+	if delay > max {
+		delay = max
+	}
+	if jitter := math.Min(math.Max(p.Jitter, 0), 1); jitter > 0 {
+		delay += delay * jitter * (2*rand.Float64() - 1)
+	}
+	if delay >= math.MaxInt64 {
+		return math.MaxInt64
+	}
+	return time.Duration(delay)
+}
+
+// retry reports whether the procedure failed with the error after the attempt
+// should be retried. Waits for delay before the next attempt.
+func (p *RetryPolicy) retry(ctx context.Context, attempt int, err error) bool {
+	if p == nil || attempt >= p.MaxAttempts {
+		return false
+	}
+	if _, isPanic := err.(panicError); isPanic {
+		return false
+	}
+	if p.Retryable != nil && !p.Retryable(err) {
+		return false
+	}
+
+	timer := time.NewTimer(p.Delay(attempt))
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return true
+	case <-ctx.Done():
+		return false
+	}
+}
@@ -0,0 +1,100 @@
+package ffsm
+
+import (
+	"context"
+	"errors"
+	"math"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRetryPolicy_Delay(t *testing.T) {
+	p := RetryPolicy{Backoff: 10 * time.Millisecond, MaxBackoff: 50 * time.Millisecond}
+	assert.Equal(t, 10*time.Millisecond, p.Delay(1))
+	assert.Equal(t, 20*time.Millisecond, p.Delay(2))
+	assert.Equal(t, 40*time.Millisecond, p.Delay(3))
+	assert.Equal(t, 50*time.Millisecond, p.Delay(4))
+	assert.Equal(t, 50*time.Millisecond, p.Delay(100))
+
+	p = RetryPolicy{Backoff: 100 * time.Millisecond, Multiplier: 1, Jitter: 0.2}
+	for i := 0; i < 100; i++ {
+		d := p.Delay(i + 1)
+		assert.True(t, d >= 80*time.Millisecond && d <= 120*time.Millisecond, d)
+	}
+
+	// without MaxBackoff the delay is not overflowed
+	p = RetryPolicy{Backoff: time.Second}
+	assert.Equal(t, time.Duration(math.MaxInt64), p.Delay(100))
+	assert.Equal(t, time.Duration(math.MaxInt64), p.Delay(10000))
+
+	// jitter is clamped to [0, 1]
+	p = RetryPolicy{Backoff: 100 * time.Millisecond, Multiplier: 1, Jitter: 5}
+	for i := 0; i < 100; i++ {
+		d := p.Delay(i + 1)
+		assert.True(t, d >= 0 && d <= 200*time.Millisecond, d)
+	}
+	p = RetryPolicy{Backoff: 100 * time.Millisecond, Multiplier: 1, Jitter: -1}
+	assert.Equal(t, 100*time.Millisecond, p.Delay(1))
+}
+
+func Test_FSM_Retry(t *testing.T) {
+	errFlaky := errors.New("flaky")
+	errFatal := errors.New("fatal")
+	var calls int
+	flaky := func(failures int, err error) Procedure {
+		return func(ctx context.Context) (context.Context, error) {
+			calls++
+			if calls <= failures {
+				return ctx, err
+			}
+			return ctx, nil
+		}
+	}
+	policy := &RetryPolicy{
+		MaxAttempts: 3,
+		Backoff:     time.Millisecond,
+		Jitter:      0.5,
+		Retryable: func(err error) bool {
+			return errors.Is(err, errFlaky) || errors.Is(err, ErrTimeout)
+		},
+	}
+
+	fsm := NewFSM(make(Stack).AddAction(CloseDoor, OpenDoor, Action{Procedure: flaky(2, errFlaky), Retry: policy}), CloseDoor)
+	assert.NoError(t, fsm.Dispatch(context.Background(), OpenDoor))
+	assert.Equal(t, 3, calls)
+
+	calls = 0
+	fsm = NewFSM(make(Stack).AddAction(CloseDoor, OpenDoor, Action{Procedure: flaky(5, errFlaky), Retry: policy}), CloseDoor)
+	err := fsm.Dispatch(context.Background(), OpenDoor)
+	var dispatchErr DispatchError
+	require.True(t, errors.As(err, &dispatchErr))
+	assert.Equal(t, 3, dispatchErr.Attempts)
+	assert.Equal(t, 3, calls)
+	assert.Equal(t, CloseDoor, fsm.State())
+
+	calls = 0
+	fsm = NewFSM(make(Stack).AddAction(CloseDoor, OpenDoor, Action{Procedure: flaky(5, errFatal), Retry: policy}), CloseDoor)
+	err = fsm.Dispatch(context.Background(), OpenDoor)
+	require.True(t, errors.As(err, &dispatchErr))
+	assert.Equal(t, 1, dispatchErr.Attempts)
+	assert.True(t, errors.Is(err, errFatal))
+
+	// timeout is applied to each attempt
+	calls = 0
+	fsm = NewFSM(make(Stack).AddAction(CloseDoor, OpenDoor, Action{
+		Procedure: func(ctx context.Context) (context.Context, error) {
+			calls++
+			if calls == 1 {
+				<-ctx.Done()
+			}
+			return ctx, nil
+		},
+		Timeout: 10 * time.Millisecond,
+		Retry:   policy,
+	}), CloseDoor)
+	assert.NoError(t, fsm.Dispatch(context.Background(), OpenDoor))
+	assert.Equal(t, 2, calls)
+}
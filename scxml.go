@@ -0,0 +1,538 @@
+package ffsm
+
+import (
+	"bytes"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+)
+
+const (
+	// SCXMLNamespace is the namespace of W3C SCXML documents.
+	SCXMLNamespace = "http://www.w3.org/2005/07/scxml"
+	// ActionNamespace is the namespace of the element <action> of SCXML
+	// document that references the procedure by name and the attribute
+	// timeout of the transition:
+	//
+	//	<transition target="paid" ffsm:timeout="5s">
+	//	  <ffsm:action name="charge" compensate="refund" timeout="1s"/>
+	//	</transition>
+	ActionNamespace = "https://github.com/gebv/ffsm"
+)
+
+// ParseSCXML parses the definition from SCXML document. Procedures of
+// <onentry>, <onexit> and <transition> are referenced by the element <action>
+// of ActionNamespace, guards are names in the attribute cond separated by &&.
+// Nested states are children states (the id of the child state is prefixed by
+// the parent state and StateSeparator if it is not).
+//
+// Constructs that can not be represented by Stack (parallel and history
+// states, targetless transitions, expressions, data model, executable content
+// other than <action>) are returned as DefinitionError with position that
+// matches ErrUnsupported.
+func ParseSCXML(data []byte) (*Definition, error) {
+	root, err := parseXMLTree(data)
+	if err != nil {
+		return nil, err
+	}
+	p := &scxmlParser{
+		d:   &Definition{pos: map[string]position{}},
+		ids: map[string]string{},
+	}
+	p.document(root)
+	if p.err != nil {
+		return nil, p.err
+	}
+	for _, ref := range p.refs {
+		name, ok := p.ids[ref.id]
+		if !ok {
+			return nil, DefinitionError{Line: ref.line, Column: ref.column, Field: ref.field, Err: fmt.Errorf("unknown state %q", ref.id)}
+		}
+		ref.set(name)
+	}
+	return p.d, nil
+}
+
+// SCXML returns SCXML document of the definition (see ParseSCXML).
+// Wildcard states and transitions of final states are returned as
+// DefinitionError that matches ErrUnsupported.
+func (d *Definition) SCXML() ([]byte, error) {
+	states := map[string]*scxmlState{}
+	root := &scxmlState{}
+	var add func(name string) *scxmlState
+	add = func(name string) *scxmlState {
+		if s, ok := states[name]; ok {
+			return s
+		}
+		parent := root
+		if p := ParentState(name); p != UnknownState {
+			parent = add(p)
+		}
+		s := &scxmlState{StateDefinition: StateDefinition{Name: name}}
+		states[name] = s
+		parent.children = append(parent.children, s)
+		return s
+	}
+	for _, s := range d.States {
+		add(s.Name).StateDefinition = s
+	}
+	var undeclared []string
+	for _, name := range append([]string{d.Initial}, d.transitionStates()...) {
+		if _, ok := states[name]; !ok && name != UnknownState && name != AnyState {
+			undeclared = append(undeclared, name)
+		}
+	}
+	sort.Strings(undeclared)
+	for _, name := range undeclared {
+		add(name)
+	}
+	for i, t := range d.Transitions {
+		field := fmt.Sprintf("transitions[%d]", i)
+		if t.From == AnyState {
+			return nil, DefinitionError{Field: field + ".from", Err: fmt.Errorf("%w: wildcard source state", ErrUnsupported)}
+		}
+		if t.To == AnyState {
+			return nil, DefinitionError{Field: field + ".to", Err: fmt.Errorf("%w: wildcard destination state", ErrUnsupported)}
+		}
+		s := states[t.From]
+		if s.Final {
+			return nil, DefinitionError{Field: field + ".from", Err: fmt.Errorf("%w: transition from final state %q", ErrUnsupported, t.From)}
+		}
+		s.transitions = append(s.transitions, t)
+	}
+
+	var b bytes.Buffer
+	b.WriteString(xml.Header)
+	b.WriteString(`<scxml`)
+	xmlAttr(&b, "xmlns", SCXMLNamespace)
+	xmlAttr(&b, "xmlns:ffsm", ActionNamespace)
+	xmlAttr(&b, "version", "1.0")
+	if d.Initial != UnknownState {
+		xmlAttr(&b, "initial", d.Initial)
+	}
+	if d.Name != "" {
+		xmlAttr(&b, "name", d.Name)
+	}
+	b.WriteString(">\n")
+	for _, s := range root.children {
+		if err := s.write(&b, 1); err != nil {
+			return nil, err
+		}
+	}
+	b.WriteString("</scxml>\n")
+	return b.Bytes(), nil
+}
+
+// SCXML returns SCXML document of the stack with the initial state (see
+// NewDefinition and Definition.SCXML).
+func (r StackOf[S, P]) SCXML(initState S) ([]byte, error) {
+	if r == nil {
+		panic("Stack.SCXML: stack is empty")
+	}
+	d, err := NewDefinition(r.names(), StateName(initState))
+	if err != nil {
+		return nil, err
+	}
+	return d.SCXML()
+}
+
+// transitionStates returns states of transitions.
+func (d *Definition) transitionStates() []string {
+	var list []string
+	for _, t := range d.Transitions {
+		list = append(list, t.From, t.To)
+	}
+	return list
+}
+
+type scxmlState struct {
+	StateDefinition
+	children    []*scxmlState
+	transitions []TransitionDefinition
+}
+
+func (s *scxmlState) write(b *bytes.Buffer, depth int) error {
+	indent := strings.Repeat("  ", depth)
+	element := "state"
+	if s.Final {
+		element = "final"
+		if len(s.children) > 0 {
+			return DefinitionError{Field: fmt.Sprintf("state %q", s.Name), Err: fmt.Errorf("%w: final state with children", ErrUnsupported)}
+		}
+	}
+	b.WriteString(indent + "<" + element)
+	xmlAttr(b, "id", s.Name)
+	if s.Initial != "" {
+		xmlAttr(b, "initial", s.Initial)
+	}
+	if len(s.Enter) == 0 && len(s.Exit) == 0 && len(s.transitions) == 0 && len(s.children) == 0 {
+		b.WriteString("/>\n")
+		return nil
+	}
+	b.WriteString(">\n")
+	writeActions(b, depth+1, "onentry", s.Enter)
+	writeActions(b, depth+1, "onexit", s.Exit)
+	for _, t := range s.transitions {
+		b.WriteString(indent + "  <transition")
+		if t.Event != "" {
+			xmlAttr(b, "event", t.Event)
+		}
+		xmlAttr(b, "target", t.To)
+		if len(t.Guards) > 0 {
+			xmlAttr(b, "cond", strings.Join(t.Guards, " && "))
+		}
+		if t.Timeout > 0 {
+			xmlAttr(b, "ffsm:timeout", t.Timeout.String())
+		}
+		if len(t.Actions) == 0 {
+			b.WriteString("/>\n")
+			continue
+		}
+		b.WriteString(">\n")
+		for _, a := range t.Actions {
+			writeAction(b, depth+2, a)
+		}
+		b.WriteString(indent + "  </transition>\n")
+	}
+	for _, c := range s.children {
+		if err := c.write(b, depth+1); err != nil {
+			return err
+		}
+	}
+	b.WriteString(indent + "</" + element + ">\n")
+	return nil
+}
+
+func writeActions(b *bytes.Buffer, depth int, element string, actions []ActionDefinition) {
+	if len(actions) == 0 {
+		return
+	}
+	indent := strings.Repeat("  ", depth)
+	b.WriteString(indent + "<" + element + ">\n")
+	for _, a := range actions {
+		writeAction(b, depth+1, a)
+	}
+	b.WriteString(indent + "</" + element + ">\n")
+}
+
+func writeAction(b *bytes.Buffer, depth int, a ActionDefinition) {
+	b.WriteString(strings.Repeat("  ", depth) + "<ffsm:action")
+	xmlAttr(b, "name", a.Name)
+	if a.Compensate != "" {
+		xmlAttr(b, "compensate", a.Compensate)
+	}
+	if a.Timeout > 0 {
+		xmlAttr(b, "timeout", a.Timeout.String())
+	}
+	b.WriteString("/>\n")
+}
+
+func xmlAttr(b *bytes.Buffer, name, value string) {
+	b.WriteString(" " + name + `="`)
+	xml.EscapeText(b, []byte(value))
+	b.WriteString(`"`)
+}
+
+// xmlNode is the element of XML document with position.
+type xmlNode struct {
+	name         xml.Name
+	attrs        []xml.Attr
+	children     []*xmlNode
+	line, column int
+}
+
+func (n *xmlNode) attr(name string) (string, bool) {
+	return n.attrNS("", name)
+}
+
+func (n *xmlNode) attrNS(space, name string) (string, bool) {
+	for _, a := range n.attrs {
+		if a.Name.Space == space && a.Name.Local == name {
+			return a.Value, true
+		}
+	}
+	return "", false
+}
+
+func (n *xmlNode) errorf(field string, err error, format string, args ...interface{}) error {
+	if err != nil {
+		format = "%w: " + format
+		args = append([]interface{}{err}, args...)
+	}
+	return DefinitionError{Line: n.line, Column: n.column, Field: field, Err: fmt.Errorf(format, args...)}
+}
+
+// parseXMLTree returns the root element of XML document.
+func parseXMLTree(data []byte) (*xmlNode, error) {
+	dec := xml.NewDecoder(bytes.NewReader(data))
+	var root *xmlNode
+	var stack []*xmlNode
+	for {
+		offset := dec.InputOffset()
+		tok, err := dec.Token()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, DefinitionError{Err: err}
+		}
+		switch tok := tok.(type) {
+		case xml.StartElement:
+			n := &xmlNode{name: tok.Name, attrs: tok.Attr}
+			n.line = 1 + bytes.Count(data[:offset], []byte("\n"))
+			n.column = int(offset) - bytes.LastIndexByte(data[:offset], '\n')
+			if len(stack) > 0 {
+				parent := stack[len(stack)-1]
+				parent.children = append(parent.children, n)
+			} else if root == nil {
+				root = n
+			}
+			stack = append(stack, n)
+		case xml.EndElement:
+			stack = stack[:len(stack)-1]
+		}
+	}
+	if root == nil {
+		return nil, DefinitionError{Err: errors.New("document is empty")}
+	}
+	return root, nil
+}
+
+var scxmlGuard = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_.\-]*$`)
+
+// scxmlRef is the reference to the state by id resolved after parsing.
+type scxmlRef struct {
+	id           string
+	field        string
+	line, column int
+	set          func(name string)
+}
+
+type scxmlParser struct {
+	d    *Definition
+	ids  map[string]string // id => name of the state
+	refs []scxmlRef
+	err  error
+}
+
+func (p *scxmlParser) fail(err error) {
+	if p.err == nil {
+		p.err = err
+	}
+}
+
+func (p *scxmlParser) ref(n *xmlNode, field, id string, set func(name string)) {
+	p.d.pos[field] = position{n.line, n.column}
+	p.refs = append(p.refs, scxmlRef{id: id, field: field, line: n.line, column: n.column, set: set})
+}
+
+// unsupported reports the element that is not the part of SCXML document
+// that can be represented by Stack.
+func (p *scxmlParser) unsupported(n *xmlNode) {
+	what := "element"
+	switch {
+	case n.name.Space != SCXMLNamespace && n.name.Space != "":
+		what = "element of unknown namespace"
+	case n.name.Local == "parallel":
+		what = "parallel states"
+	case n.name.Local == "history":
+		what = "history states"
+	case n.name.Local == "datamodel" || n.name.Local == "data":
+		what = "data model"
+	case n.name.Local == "invoke":
+		what = "invoke"
+	}
+	p.fail(n.errorf("<"+n.name.Local+">", ErrUnsupported, "%s", what))
+}
+
+func isSCXML(n *xmlNode, local string) bool {
+	return (n.name.Space == SCXMLNamespace || n.name.Space == "") && n.name.Local == local
+}
+
+func (p *scxmlParser) document(n *xmlNode) {
+	if !isSCXML(n, "scxml") {
+		p.fail(n.errorf("", nil, "expected <scxml>, got <%s>", n.name.Local))
+		return
+	}
+	p.d.Name, _ = n.attr("name")
+	initial, hasInitial := n.attr("initial")
+	if strings.Contains(strings.TrimSpace(initial), " ") {
+		p.fail(n.errorf("initial", ErrUnsupported, "multiple initial states"))
+		return
+	}
+	if hasInitial {
+		p.ref(n, "initial", strings.TrimSpace(initial), func(name string) { p.d.Initial = name })
+	}
+	for _, c := range n.children {
+		if p.err != nil {
+			return
+		}
+		switch {
+		case isSCXML(c, "state"), isSCXML(c, "final"):
+			name := p.state(c, UnknownState)
+			if !hasInitial && p.d.Initial == UnknownState {
+				p.d.Initial = name
+			}
+		default:
+			p.unsupported(c)
+		}
+	}
+}
+
+// state parses the state and its children and returns the name of the state.
+func (p *scxmlParser) state(n *xmlNode, parent string) string {
+	id, _ := n.attr("id")
+	if id == "" {
+		p.fail(n.errorf("<"+n.name.Local+">", nil, "required attribute id"))
+		return ""
+	}
+	name := id
+	if parent != UnknownState && !isDescendant(id, parent) {
+		name = parent + StateSeparator + id
+	}
+	if _, ok := p.ids[id]; ok {
+		p.fail(n.errorf("<"+n.name.Local+">", nil, "duplicate state %q", id))
+		return ""
+	}
+	p.ids[id] = name
+
+	i := len(p.d.States)
+	field := fmt.Sprintf("states[%d]", i)
+	p.d.pos[field] = position{n.line, n.column}
+	p.d.States = append(p.d.States, StateDefinition{Name: name, Final: n.name.Local == "final"})
+	setInitial := func(name string) { p.d.States[i].Initial = name }
+
+	initial, hasInitial := n.attr("initial")
+	if hasInitial {
+		if strings.Contains(strings.TrimSpace(initial), " ") {
+			p.fail(n.errorf(field+".initial", ErrUnsupported, "multiple initial states"))
+			return name
+		}
+		p.ref(n, field+".initial", strings.TrimSpace(initial), setInitial)
+	}
+	for _, c := range n.children {
+		if p.err != nil {
+			return name
+		}
+		switch {
+		case isSCXML(c, "state") && n.name.Local == "state", isSCXML(c, "final") && n.name.Local == "state":
+			child := p.state(c, name)
+			if !hasInitial && p.d.States[i].Initial == UnknownState {
+				p.d.States[i].Initial = child
+			}
+		case isSCXML(c, "initial"):
+			hasInitial = true
+			if len(c.children) != 1 || !isSCXML(c.children[0], "transition") || len(c.children[0].children) > 0 {
+				p.fail(c.errorf(field+".initial", ErrUnsupported, "<initial> without single transition"))
+				return name
+			}
+			target, _ := c.children[0].attr("target")
+			p.ref(c.children[0], field+".initial", strings.TrimSpace(target), setInitial)
+		case isSCXML(c, "transition") && n.name.Local == "state":
+			p.transition(c, name)
+		case isSCXML(c, "onentry"):
+			p.d.States[i].Enter = p.actions(c, field+".enter")
+		case isSCXML(c, "onexit"):
+			p.d.States[i].Exit = p.actions(c, field+".exit")
+		default:
+			p.unsupported(c)
+		}
+	}
+	return name
+}
+
+func (p *scxmlParser) transition(n *xmlNode, from string) {
+	target, _ := n.attr("target")
+	target = strings.TrimSpace(target)
+	switch {
+	case target == "":
+		p.fail(n.errorf("<transition>", ErrUnsupported, "targetless transition"))
+		return
+	case strings.Contains(target, " "):
+		p.fail(n.errorf("<transition>", ErrUnsupported, "multiple targets"))
+		return
+	}
+
+	var guards []string
+	if cond, ok := n.attr("cond"); ok {
+		for _, g := range strings.Split(cond, "&&") {
+			g = strings.TrimSpace(g)
+			if !scxmlGuard.MatchString(g) {
+				p.fail(n.errorf("<transition>", ErrUnsupported, "condition expression %q (expected names of guards separated by &&)", cond))
+				return
+			}
+			guards = append(guards, g)
+		}
+	}
+
+	var timeout time.Duration
+	if v, ok := n.attrNS(ActionNamespace, "timeout"); ok {
+		var err error
+		if timeout, err = time.ParseDuration(v); err != nil {
+			p.fail(n.errorf("<transition>", nil, "expected duration of timeout"))
+			return
+		}
+	}
+
+	event, _ := n.attr("event")
+	events := strings.Fields(event)
+	if len(events) == 0 {
+		events = []string{""}
+	}
+	for _, event := range events {
+		if event == "*" || strings.HasSuffix(event, ".*") {
+			p.fail(n.errorf("<transition>", ErrUnsupported, "wildcard event %q", event))
+			return
+		}
+		i := len(p.d.Transitions)
+		field := fmt.Sprintf("transitions[%d]", i)
+		p.d.pos[field] = position{n.line, n.column}
+		for j := range guards {
+			p.d.pos[fmt.Sprintf("%s.guards[%d]", field, j)] = position{n.line, n.column}
+		}
+		p.d.Transitions = append(p.d.Transitions, TransitionDefinition{
+			From:    from,
+			Event:   event,
+			Timeout: timeout,
+			Guards:  guards,
+			Actions: p.actions(n, field+".actions"),
+		})
+		p.ref(n, field+".to", target, func(name string) { p.d.Transitions[i].To = name })
+	}
+}
+
+func (p *scxmlParser) actions(n *xmlNode, field string) []ActionDefinition {
+	var list []ActionDefinition
+	for _, c := range n.children {
+		if c.name.Space != ActionNamespace || c.name.Local != "action" {
+			p.fail(c.errorf("<"+c.name.Local+">", ErrUnsupported, "executable content (expected <action> of %s)", ActionNamespace))
+			return nil
+		}
+		sub := fmt.Sprintf("%s[%d]", field, len(list))
+		p.d.pos[sub] = position{c.line, c.column}
+		var a ActionDefinition
+		a.Name, _ = c.attr("name")
+		if a.Name == "" {
+			p.fail(c.errorf(sub, nil, "required attribute name"))
+			return nil
+		}
+		if compensate, ok := c.attr("compensate"); ok {
+			a.Compensate = compensate
+			p.d.pos[sub+".compensate"] = position{c.line, c.column}
+		}
+		if timeout, ok := c.attr("timeout"); ok {
+			var err error
+			if a.Timeout, err = time.ParseDuration(timeout); err != nil {
+				p.fail(c.errorf(sub+".timeout", nil, "expected duration"))
+				return nil
+			}
+		}
+		list = append(list, a)
+	}
+	return list
+}
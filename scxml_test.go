@@ -0,0 +1,175 @@
+package ffsm
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const doorSCXML = `<?xml version="1.0" encoding="UTF-8"?>
+<scxml xmlns="http://www.w3.org/2005/07/scxml" xmlns:ffsm="https://github.com/gebv/ffsm" version="1.0" initial="close" name="door">
+  <state id="close">
+    <transition event="open" target="open" cond="onlyBob">
+      <ffsm:action name="log"/>
+      <ffsm:action name="charge" compensate="refund" timeout="1s"/>
+    </transition>
+    <transition event="push" target="open.ajar"/>
+  </state>
+  <state id="open" initial="open.wide">
+    <onentry>
+      <ffsm:action name="log"/>
+    </onentry>
+    <transition target="close" ffsm:timeout="5s"/>
+    <transition event="break" target="broken"/>
+    <state id="open.wide"/>
+    <state id="open.ajar"/>
+  </state>
+  <final id="broken"/>
+</scxml>
+`
+
+func Test_SCXML_Import(t *testing.T) {
+	d, err := ParseSCXML([]byte(doorSCXML))
+	require.NoError(t, err)
+	assert.Equal(t, "door", d.Name)
+	assert.Equal(t, CloseDoor, d.Initial)
+	assert.Equal(t, []string{"close", "open", "open.wide", "open.ajar", "broken"}, d.StateNames())
+	assert.Equal(t, "open.wide", d.States[1].Initial)
+	assert.Equal(t, []string{"broken"}, d.Finals())
+	require.Len(t, d.Transitions, 4)
+	assert.Equal(t, TransitionDefinition{
+		From:   CloseDoor,
+		To:     OpenDoor,
+		Event:  "open",
+		Guards: []string{"onlyBob"},
+		Actions: []ActionDefinition{
+			{Name: "log"},
+			{Name: "charge", Compensate: "refund", Timeout: 1e9},
+		},
+	}, d.Transitions[0])
+	assert.Equal(t, 5*time.Second, d.Transitions[2].Timeout)
+
+	var calls []string
+	wf, err := d.Build(doorRegistry(&calls))
+	require.NoError(t, err)
+	assert.True(t, wf.Validate(d.Initial, d.StateNames(), d.Finals()).OK())
+
+	fsm := NewFSM(wf, d.Initial)
+	defer fsm.Stop()
+	err = fsm.DispatchEvent(context.WithValue(context.Background(), "__name", "bob"), "open")
+	require.NoError(t, err)
+	assert.Equal(t, "open.wide", fsm.State())
+	assert.Equal(t, []string{"log", "charge", "log"}, calls)
+	assert.NoError(t, fsm.DispatchEvent(context.Background(), "break"))
+}
+
+func Test_SCXML_ImportNested(t *testing.T) {
+	d, err := ParseSCXML([]byte(`<scxml xmlns="http://www.w3.org/2005/07/scxml" version="1.0">
+  <state id="idle">
+    <transition event="start stop" target="picking"/>
+  </state>
+  <state id="active">
+    <initial><transition target="packing"/></initial>
+    <state id="picking"/>
+    <state id="packing"/>
+  </state>
+  <state id="done">
+    <state id="ok"/>
+  </state>
+</scxml>`))
+	require.NoError(t, err)
+	assert.Equal(t, "idle", d.Initial) // first state
+	assert.Equal(t, []string{"idle", "active", "active.picking", "active.packing", "done", "done.ok"}, d.StateNames())
+	assert.Equal(t, "active.packing", d.States[1].Initial)
+	assert.Equal(t, "done.ok", d.States[4].Initial) // first child
+	require.Len(t, d.Transitions, 2)
+	assert.Equal(t, TransitionDefinition{From: "idle", To: "active.picking", Event: "start"}, d.Transitions[0])
+	assert.Equal(t, TransitionDefinition{From: "idle", To: "active.picking", Event: "stop"}, d.Transitions[1])
+}
+
+func Test_SCXML_Export(t *testing.T) {
+	var calls []string
+	reg := doorRegistry(&calls)
+	d, err := ParseSCXML([]byte(doorSCXML))
+	require.NoError(t, err)
+
+	data, err := d.SCXML()
+	require.NoError(t, err)
+	assert.Equal(t, doorSCXML, string(data))
+
+	// Stack => SCXML => Stack
+	wf, err := d.Build(reg)
+	require.NoError(t, err)
+	delete(wf, StackKey{Src: CloseDoor, Dst: OpenDoor, Event: "open"}) // with compensation
+	data, err = wf.SCXML(CloseDoor)
+	require.NoError(t, err)
+	d, err = ParseSCXML(data)
+	require.NoError(t, err)
+	got, err := d.Build(reg)
+	require.NoError(t, err)
+	want, err := NewDefinition(wf, CloseDoor)
+	require.NoError(t, err)
+	exported, err := NewDefinition(got, CloseDoor)
+	require.NoError(t, err)
+	assert.Equal(t, want, exported)
+
+	// not representable
+	_, err = make(Stack).Add(AnyState, "broken").SCXML(CloseDoor)
+	assert.True(t, errors.Is(err, ErrUnsupported))
+	assert.EqualError(t, err, "transitions[0].from: Unsupported construct: wildcard source state")
+	_, err = make(Stack).Add(CloseDoor, OpenDoor, func(ctx context.Context) (context.Context, error) { return ctx, nil }).SCXML(CloseDoor)
+	assert.True(t, errors.Is(err, ErrInvalidDefinition))
+	assert.EqualError(t, err, "transitions[0].actions[0]: procedure without name")
+}
+
+func Test_SCXML_Unsupported(t *testing.T) {
+	const header = `<scxml xmlns="http://www.w3.org/2005/07/scxml" xmlns:ffsm="https://github.com/gebv/ffsm" version="1.0">`
+	cases := []struct {
+		name string
+		doc  string
+		want string
+	}{
+		{
+			"parallel",
+			header + "\n  <parallel id=\"p\"/>\n</scxml>",
+			"line 2:3: <parallel>: Unsupported construct: parallel states",
+		},
+		{
+			"history",
+			header + "\n  <state id=\"a\">\n    <history id=\"h\"/>\n  </state>\n</scxml>",
+			"line 3:5: <history>: Unsupported construct: history states",
+		},
+		{
+			"targetless transition",
+			header + "\n  <state id=\"a\">\n    <transition event=\"e\"/>\n  </state>\n</scxml>",
+			"line 3:5: <transition>: Unsupported construct: targetless transition",
+		},
+		{
+			"expression",
+			header + "\n  <state id=\"a\">\n    <transition target=\"a\" cond=\"x &gt; 1\"/>\n  </state>\n</scxml>",
+			`line 3:5: <transition>: Unsupported construct: condition expression "x > 1" (expected names of guards separated by &&)`,
+		},
+		{
+			"executable content",
+			header + "\n  <state id=\"a\">\n    <onentry><log expr=\"1\"/></onentry>\n  </state>\n</scxml>",
+			"line 3:14: <log>: Unsupported construct: executable content (expected <action> of https://github.com/gebv/ffsm)",
+		},
+		{
+			"unknown state",
+			header + "\n  <state id=\"a\">\n    <transition target=\"b\"/>\n  </state>\n</scxml>",
+			`line 3:5: transitions[0].to: unknown state "b"`,
+		},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			_, err := ParseSCXML([]byte(c.doc))
+			require.Error(t, err)
+			assert.True(t, errors.Is(err, ErrInvalidDefinition))
+			assert.Equal(t, c.want, err.Error())
+		})
+	}
+}
@@ -1,38 +1,640 @@
 package ffsm
 
-import "context"
+import (
+	"context"
+	"fmt"
+	"time"
+)
 
-// Stack actions of transition.
-type Stack map[StackKey][]Procedure
+// StackOf actions of transition between states of type S with the payload
+// of type P (see FSMOf).
+//
+// The zero value of S is UnknownState. States of the type with underlying
+// type string have hierarchy (see StateSeparator) and wildcard AnyState,
+// states of other types are flat.
+type StackOf[S comparable, P any] map[StackKeyOf[S]][]ActionOf[P]
 
-// StackKey is the identifier of the transition.
-type StackKey struct {
-	Src string
-	Dst string
+// Stack actions of transition between states named by strings.
+type Stack = StackOf[string, interface{}]
+
+// StackKeyOf is the identifier of the transition.
+//
+// Event is empty for transitions registered by Add and is the name of the
+// event for transitions registered by AddEvent.
+//
+// Kind is TransitionKind for transitions. Hooks of the state are stored by
+// keys of EnterKind (state in Dst) and ExitKind (state in Src), timeouts of
+// transitions by keys of TimeoutKind.
+type StackKeyOf[S comparable] struct {
+	Src   S
+	Dst   S
+	Event string
+	Kind  KeyKind
+}
+
+// StackKey is the identifier of the transition of Stack.
+type StackKey = StackKeyOf[string]
+
+// AnyState is the wildcard of source or destination state of the transition.
+const AnyState = "*"
+
+// KeyKind is the kind of the entry of Stack.
+type KeyKind uint8
+
+const (
+	// TransitionKind is the kind of transition from Src to Dst.
+	TransitionKind KeyKind = iota
+	// EnterKind is the kind of hooks executed on entering the state Dst.
+	EnterKind
+	// ExitKind is the kind of hooks executed on exiting the state Src.
+	ExitKind
+	// InitialKind is the kind of the initial child Dst of the parent state Src.
+	InitialKind
+	// TimeoutKind is the kind of the timeout of the transition from Src to
+	// Dst (or by Event from Src, Dst is empty).
+	TimeoutKind
+)
+
+func (k KeyKind) String() string {
+	switch k {
+	case TransitionKind:
+		return "transition"
+	case EnterKind:
+		return "enter"
+	case ExitKind:
+		return "exit"
+	case InitialKind:
+		return "initial"
+	case TimeoutKind:
+		return "timeout"
+	}
+	return fmt.Sprintf("KeyKind(%d)", uint8(k))
+}
+
+// ActionOf is the registered handler of the transition with the payload of
+// type P.
+type ActionOf[P any] struct {
+	// Name of the action for interceptors, errors and metrics (optional).
+	Name      string
+	Procedure Procedure
+
+	// PayloadProcedure is executed instead of Procedure if it is set. It gets
+	// the payload of the transition (see FSM.DispatchPayload) and returns
+	// the payload for the next actions (the payload of the guard is ignored).
+	PayloadProcedure PayloadProcedureOf[P]
+
+	// Guard marks the procedure as the check of the transition. Guards are
+	// executed before any other action of the transition.
+	Guard bool
+
+	// Compensate undoes side effects of the procedure. If one of the next
+	// actions of the transition fails or panics the compensations of already
+	// executed actions are executed in reverse order.
+	Compensate Procedure
+
+	// PayloadCompensate is executed instead of Compensate if it is set. It
+	// gets the payload of the transition at the failure.
+	PayloadCompensate PayloadProcedureOf[P]
+
+	// Timeout of the procedure (zero is without timeout). If the timeout is
+	// exceeded the context of the procedure is canceled and the transition
+	// fails with ErrTimeout.
+	Timeout time.Duration
+
+	// Retry is the policy of retries of the failed procedure (nil is without
+	// retries). The timeout is applied to each attempt.
+	Retry *RetryPolicy
 }
 
+// Action is the registered handler of the transition of Stack.
+type Action = ActionOf[interface{}]
+
 // Add registration action.
-func (r Stack) Add(src string, dst string, p ...Procedure) Stack {
+func (r StackOf[S, P]) Add(src S, dst S, p ...Procedure) StackOf[S, P] {
 	if r == nil {
 		panic("Stack.Add: stack is empty")
 	}
 
-	e := StackKey{Src: src, Dst: dst}
-	if r[e] == nil {
-		r[e] = []Procedure{}
+	r.add(StackKeyOf[S]{Src: src, Dst: dst}, procedureActions[P](p)...)
+
+	return r
+}
+
+// AddAction registration action with options of the transition.
+func (r StackOf[S, P]) AddAction(src S, dst S, a ...ActionOf[P]) StackOf[S, P] {
+	if r == nil {
+		panic("Stack.AddAction: stack is empty")
 	}
-	r[e] = append(r[e], p...)
+
+	r.add(StackKeyOf[S]{Src: src, Dst: dst}, a...)
 
 	return r
 }
 
-// Get return actions of event.
-func (r Stack) Get(src, dst string) []Procedure {
+// AddGuard registration guard of the transition.
+func (r StackOf[S, P]) AddGuard(src S, dst S, g ...Guard) StackOf[S, P] {
+	if r == nil {
+		panic("Stack.AddGuard: stack is empty")
+	}
+
+	r.add(StackKeyOf[S]{Src: src, Dst: dst}, guardActions[P](g)...)
+
+	return r
+}
+
+// Get return actions of event. Wildcards are resolved as by Resolve.
+func (r StackOf[S, P]) Get(src, dst S) []Procedure {
 	if r == nil {
 		panic("Stack.Get: stack is empty")
 	}
-	return r[StackKey{Src: src, Dst: dst}]
+	k, ok := r.Resolve(src, dst, "")
+	if !ok {
+		return nil
+	}
+	return actionProcedures(r[k])
+}
+
+// AddEvent registration action of the transition from src to dst by the event.
+//
+// Only one destination state can be registered for the pair of source state
+// and event.
+func (r StackOf[S, P]) AddEvent(src S, event string, dst S, p ...Procedure) StackOf[S, P] {
+	if r == nil {
+		panic("Stack.AddEvent: stack is empty")
+	}
+
+	r.add(r.eventKey("Stack.AddEvent", src, event, dst), procedureActions[P](p)...)
+
+	return r
+}
+
+// AddEventAction registration action with options of the transition from src to dst by the event.
+func (r StackOf[S, P]) AddEventAction(src S, event string, dst S, a ...ActionOf[P]) StackOf[S, P] {
+	if r == nil {
+		panic("Stack.AddEventAction: stack is empty")
+	}
+
+	r.add(r.eventKey("Stack.AddEventAction", src, event, dst), a...)
+
+	return r
+}
+
+// AddEventGuard registration guard of the transition from src to dst by the event.
+func (r StackOf[S, P]) AddEventGuard(src S, event string, dst S, g ...Guard) StackOf[S, P] {
+	if r == nil {
+		panic("Stack.AddEventGuard: stack is empty")
+	}
+
+	r.add(r.eventKey("Stack.AddEventGuard", src, event, dst), guardActions[P](g)...)
+
+	return r
+}
+
+// AddPayload registration procedures with the payload of the transition
+// (see ActionOf.PayloadProcedure).
+func (r StackOf[S, P]) AddPayload(src S, dst S, p ...PayloadProcedureOf[P]) StackOf[S, P] {
+	if r == nil {
+		panic("Stack.AddPayload: stack is empty")
+	}
+
+	r.add(StackKeyOf[S]{Src: src, Dst: dst}, payloadActions(p)...)
+
+	return r
+}
+
+// AddEventPayload registration procedures with the payload of the transition
+// from src to dst by the event.
+func (r StackOf[S, P]) AddEventPayload(src S, event string, dst S, p ...PayloadProcedureOf[P]) StackOf[S, P] {
+	if r == nil {
+		panic("Stack.AddEventPayload: stack is empty")
+	}
+
+	r.add(r.eventKey("Stack.AddEventPayload", src, event, dst), payloadActions(p)...)
+
+	return r
+}
+
+// OnEnter registration hook executed on entering the state by any transition.
+func (r StackOf[S, P]) OnEnter(state S, p ...Procedure) StackOf[S, P] {
+	if r == nil {
+		panic("Stack.OnEnter: stack is empty")
+	}
+
+	r.add(StackKeyOf[S]{Dst: state, Kind: EnterKind}, procedureActions[P](p)...)
+
+	return r
+}
+
+// OnExit registration hook executed on exiting the state by any transition.
+func (r StackOf[S, P]) OnExit(state S, p ...Procedure) StackOf[S, P] {
+	if r == nil {
+		panic("Stack.OnExit: stack is empty")
+	}
+
+	r.add(StackKeyOf[S]{Src: state, Kind: ExitKind}, procedureActions[P](p)...)
+
+	return r
+}
+
+// OnEnterAction registration hook with options executed on entering the state.
+func (r StackOf[S, P]) OnEnterAction(state S, a ...ActionOf[P]) StackOf[S, P] {
+	if r == nil {
+		panic("Stack.OnEnterAction: stack is empty")
+	}
+
+	r.add(StackKeyOf[S]{Dst: state, Kind: EnterKind}, a...)
+
+	return r
+}
+
+// OnExitAction registration hook with options executed on exiting the state.
+func (r StackOf[S, P]) OnExitAction(state S, a ...ActionOf[P]) StackOf[S, P] {
+	if r == nil {
+		panic("Stack.OnExitAction: stack is empty")
+	}
+
+	r.add(StackKeyOf[S]{Src: state, Kind: ExitKind}, a...)
+
+	return r
+}
+
+// SetTimeout sets timeout of the transition from src to dst instead of the
+// timeout of FSM (see FSM.SetTransitionTimeout), zero is without timeout.
+// The timeout of the transition is inherited as the transition (see Resolve).
+func (r StackOf[S, P]) SetTimeout(src, dst S, timeout time.Duration) StackOf[S, P] {
+	if r == nil {
+		panic("Stack.SetTimeout: stack is empty")
+	}
+
+	r[StackKeyOf[S]{Src: src, Dst: dst, Kind: TimeoutKind}] = []ActionOf[P]{{Timeout: timeout}}
+
+	return r
+}
+
+// SetEventTimeout sets timeout of the transition from src by the event (see
+// SetTimeout).
+func (r StackOf[S, P]) SetEventTimeout(src S, event string, timeout time.Duration) StackOf[S, P] {
+	if r == nil {
+		panic("Stack.SetEventTimeout: stack is empty")
+	}
+
+	r[StackKeyOf[S]{Src: src, Event: event, Kind: TimeoutKind}] = []ActionOf[P]{{Timeout: timeout}}
+
+	return r
+}
+
+// Timeout returns timeout of the transition registered by key (see Resolve).
+// Returns false if the timeout of the transition is not set.
+func (r StackOf[S, P]) Timeout(key StackKeyOf[S]) (time.Duration, bool) {
+	if r == nil {
+		panic("Stack.Timeout: stack is empty")
+	}
+	k := StackKeyOf[S]{Src: key.Src, Dst: key.Dst, Kind: TimeoutKind}
+	if key.Event != "" {
+		k = StackKeyOf[S]{Src: key.Src, Event: key.Event, Kind: TimeoutKind}
+	}
+	actions, ok := r[k]
+	if !ok || len(actions) == 0 {
+		return 0, false
+	}
+	return actions[0].Timeout, true
+}
+
+// Target returns destination state of the event for source state.
+// Event registered from AnyState is used if the event from src is not registered.
+func (r StackOf[S, P]) Target(src S, event string) (S, bool) {
+	if r == nil {
+		panic("Stack.Target: stack is empty")
+	}
+	var unknown S
+	k, ok := r.Resolve(src, unknown, event)
+	return k.Dst, ok
+}
+
+// Resolve returns key of the registered transition that matches transition
+// from src to dst (or by the event if it is not empty).
+//
+// Exact match beats wildcard, the child state inherits transitions of the
+// parent states. Keys are checked in order:
+//
+//	{src, dst}, {src, AnyState},
+//	{parent of src, dst}, {parent of src, AnyState}, ... (up to top-level state)
+//	{AnyState, dst}, {AnyState, AnyState}
+//
+// For the event keys are checked in order {src, event}, {parent of src, event},
+// ... (up to top-level state), {AnyState, event}.
+func (r StackOf[S, P]) Resolve(src, dst S, event string) (StackKeyOf[S], bool) {
+	if r == nil {
+		panic("Stack.Resolve: stack is empty")
+	}
+	path := statePath(src)
+	wildcard, hasWildcard := anyState[S]()
+	if event != "" {
+		var found StackKeyOf[S]
+		best := -1 // index in path, len(path) for AnyState
+		for k := range r {
+			if k.Kind != TransitionKind || k.Event != event {
+				continue
+			}
+			rank := -1
+			if hasWildcard && k.Src == wildcard {
+				rank = len(path)
+			}
+			for i := range path {
+				if path[i] == k.Src {
+					rank = len(path) - 1 - i
+				}
+			}
+			if rank >= 0 && (best < 0 || rank < best) {
+				found, best = k, rank
+			}
+		}
+		return found, best >= 0
+	}
+	if hasWildcard && dst == wildcard {
+		return StackKeyOf[S]{}, false
+	}
+	for i := len(path) - 1; i >= -1; i-- {
+		var s S
+		if i >= 0 {
+			s = path[i]
+		} else if hasWildcard {
+			s = wildcard
+		} else {
+			break
+		}
+		keys := []StackKeyOf[S]{{Src: s, Dst: dst}}
+		if hasWildcard {
+			keys = append(keys, StackKeyOf[S]{Src: s, Dst: wildcard})
+		}
+		for _, k := range keys {
+			if _, ok := r[k]; ok {
+				return k, true
+			}
+		}
+	}
+	return StackKeyOf[S]{}, false
+}
+
+// GetEvent returns destination state and actions of the event for source state.
+func (r StackOf[S, P]) GetEvent(src S, event string) (S, []Procedure) {
+	if r == nil {
+		panic("Stack.GetEvent: stack is empty")
+	}
+	var unknown S
+	k, ok := r.Resolve(src, unknown, event)
+	if !ok {
+		return unknown, nil
+	}
+	return k.Dst, actionProcedures(r[k])
+}
+
+func (r StackOf[S, P]) eventKey(method string, src S, event string, dst S) StackKeyOf[S] {
+	if event == "" {
+		panic(method + ": event is empty")
+	}
+	if isAnyState(dst) {
+		panic(method + ": destination state of event can not be any state")
+	}
+	for k := range r {
+		if k.Kind == TransitionKind && k.Event == event && k.Src == src && k.Dst != dst {
+			panic(fmt.Sprintf("%s: event %q from %q already registered to %q", method, event, StateName(src), StateName(k.Dst)))
+		}
+	}
+	return StackKeyOf[S]{Src: src, Dst: dst, Event: event}
+}
+
+// step is the action of the transition in order of execution.
+type step[P any] struct {
+	ActionOf[P]
+	kind  KeyKind
+	index int // index in list of the entry of Stack
+}
+
+// plan returns steps of the transition from src to target registered by key
+// in order of execution: guards of transition, hooks on exit states (from src
+// up to the common ancestor), actions of transition, hooks on enter states
+// (from the common ancestor down to dst). The dst is the target or its
+// initial child.
+func (r StackOf[S, P]) plan(k StackKeyOf[S], src, target, dst S) []step[P] {
+	actions := r[k]
+	srcPath := statePath(src)
+	depth := lcaDepth(srcPath, statePath(target))
+
+	steps := make([]step[P], 0, len(actions))
+	guards := 0
+	for guards < len(actions) && actions[guards].Guard {
+		steps = append(steps, step[P]{ActionOf: actions[guards], kind: TransitionKind, index: guards})
+		guards++
+	}
+	for i := len(srcPath) - 1; i >= depth; i-- {
+		for j, a := range r[StackKeyOf[S]{Src: srcPath[i], Kind: ExitKind}] {
+			steps = append(steps, step[P]{ActionOf: a, kind: ExitKind, index: j})
+		}
+	}
+	for i := guards; i < len(actions); i++ {
+		steps = append(steps, step[P]{ActionOf: actions[i], kind: TransitionKind, index: i})
+	}
+	dstPath := statePath(dst)
+	for i := depth; i < len(dstPath); i++ {
+		for j, a := range r[StackKeyOf[S]{Dst: dstPath[i], Kind: EnterKind}] {
+			steps = append(steps, step[P]{ActionOf: a, kind: EnterKind, index: j})
+		}
+	}
+	return steps
+}
+
+// add appends actions of the transition. Guards are placed after already
+// registered guards and before the other actions so that the list is always
+// in order of execution.
+func (r StackOf[S, P]) add(k StackKeyOf[S], actions ...ActionOf[P]) {
+	list := r[k]
+	if list == nil {
+		list = []ActionOf[P]{}
+	}
+	for _, a := range actions {
+		if !a.Guard {
+			list = append(list, a)
+			continue
+		}
+		i := 0
+		for i < len(list) && list[i].Guard {
+			i++
+		}
+		list = append(list, ActionOf[P]{})
+		copy(list[i+1:], list[i:])
+		list[i] = a
+	}
+	r[k] = list
+}
+
+// names returns Stack of the stack with names of states (see StateName) for
+// validation, diagrams and definitions.
+func (r StackOf[S, P]) names() Stack {
+	if wf, ok := interface{}(r).(Stack); ok {
+		return wf
+	}
+	wf := make(Stack, len(r))
+	for k, actions := range r {
+		list := make([]Action, len(actions))
+		for i, a := range actions {
+			list[i] = a.untyped()
+		}
+		wf[StackKey{Src: StateName(k.Src), Dst: StateName(k.Dst), Event: k.Event, Kind: k.Kind}] = list
+	}
+	return wf
+}
+
+func procedureActions[P any](p []Procedure) []ActionOf[P] {
+	actions := make([]ActionOf[P], 0, len(p))
+	for _, fn := range p {
+		actions = append(actions, ActionOf[P]{Procedure: fn})
+	}
+	return actions
+}
+
+func payloadActions[P any](p []PayloadProcedureOf[P]) []ActionOf[P] {
+	actions := make([]ActionOf[P], 0, len(p))
+	for _, fn := range p {
+		actions = append(actions, ActionOf[P]{PayloadProcedure: fn})
+	}
+	return actions
+}
+
+// empty reports whether the action has not the procedure.
+func (a ActionOf[P]) empty() bool {
+	return a.Procedure == nil && a.PayloadProcedure == nil
+}
+
+// handler is the procedure of the action with the payload of the transition.
+type handler[P any] func(ctx context.Context, payload P) (context.Context, P, error)
+
+// handler returns PayloadProcedure or Procedure of the action.
+func (a ActionOf[P]) handler() handler[P] {
+	if p := a.PayloadProcedure; p != nil {
+		return payloadHandler(p)
+	}
+	return procedureHandler[P](a.Procedure)
+}
+
+// compensation returns PayloadCompensate or Compensate of the action, nil if
+// the action has not the compensation.
+func (a ActionOf[P]) compensation() handler[P] {
+	if p := a.PayloadCompensate; p != nil {
+		return payloadHandler(p)
+	}
+	if a.Compensate != nil {
+		return procedureHandler[P](a.Compensate)
+	}
+	return nil
+}
+
+// untyped returns the action with the payload of any type, the payload of
+// other type is passed to procedures as zero value.
+func (a ActionOf[P]) untyped() Action {
+	return Action{
+		Name:              a.Name,
+		Procedure:         a.Procedure,
+		PayloadProcedure:  a.PayloadProcedure.untyped(),
+		Guard:             a.Guard,
+		Compensate:        a.Compensate,
+		PayloadCompensate: a.PayloadCompensate.untyped(),
+		Timeout:           a.Timeout,
+		Retry:             a.Retry,
+	}
+}
+
+func payloadHandler[P any](p PayloadProcedureOf[P]) handler[P] {
+	return func(ctx context.Context, payload P) (context.Context, P, error) {
+		payload, err := p(ctx, payload)
+		return ctx, payload, err
+	}
+}
+
+func procedureHandler[P any](p Procedure) handler[P] {
+	return func(ctx context.Context, payload P) (context.Context, P, error) {
+		ctx, err := p(ctx)
+		return ctx, payload, err
+	}
+}
+
+func guardActions[P any](g []Guard) []ActionOf[P] {
+	actions := make([]ActionOf[P], 0, len(g))
+	for _, fn := range g {
+		actions = append(actions, ActionOf[P]{Procedure: fn.procedure(), Guard: true})
+	}
+	return actions
+}
+
+func actionProcedures[P any](actions []ActionOf[P]) []Procedure {
+	if actions == nil {
+		return nil
+	}
+	p := make([]Procedure, 0, len(actions))
+	for _, a := range actions {
+		p = append(p, a.procedure())
+	}
+	return p
+}
+
+// procedure returns Procedure of the action, PayloadProcedure is executed
+// with zero value of the payload.
+func (a ActionOf[P]) procedure() Procedure {
+	if a.PayloadProcedure == nil {
+		return a.Procedure
+	}
+	fn := a.handler()
+	return func(ctx context.Context) (context.Context, error) {
+		var payload P
+		ctx, _, err := fn(ctx, payload)
+		return ctx, err
+	}
+}
+
+// Named returns the action of the procedure with the name for Stack.AddAction
+// and Stack.AddEventAction. The name is returned in DispatchError.ActionName,
+// ActionInfo and metrics.
+func Named(name string, p Procedure) Action {
+	return Action{Name: name, Procedure: p}
+}
+
+// NamedGuard returns the action of the guard with the name (see Named).
+func NamedGuard(name string, g Guard) Action {
+	return Action{Name: name, Procedure: g.procedure(), Guard: true}
 }
 
 // Procedure handler of transition.
 type Procedure func(ctx context.Context) (context.Context, error)
+
+// PayloadProcedureOf handler of transition with the payload of the
+// transition of type P.
+type PayloadProcedureOf[P any] func(ctx context.Context, payload P) (P, error)
+
+// PayloadProcedure handler of transition with the payload of the transition.
+type PayloadProcedure = PayloadProcedureOf[interface{}]
+
+// untyped returns the procedure with the payload of any type.
+func (p PayloadProcedureOf[P]) untyped() PayloadProcedure {
+	if p == nil {
+		return nil
+	}
+	if fn, ok := interface{}(p).(PayloadProcedure); ok {
+		return fn
+	}
+	return func(ctx context.Context, payload interface{}) (interface{}, error) {
+		v, _ := payload.(P)
+		return p(ctx, v)
+	}
+}
+
+// Guard checks of transition. Returned error refuses the transition.
+type Guard func(ctx context.Context) error
+
+func (g Guard) procedure() Procedure {
+	if g == nil {
+		return nil
+	}
+	return func(ctx context.Context) (context.Context, error) {
+		return ctx, g(ctx)
+	}
+}
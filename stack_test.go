@@ -1,6 +1,7 @@
 package ffsm
 
 import (
+	"context"
 	"sync"
 	"testing"
 
@@ -28,6 +29,92 @@ func TestStack_AddAndGet(t *testing.T) {
 	assert.Len(t, s.Get("not", "exsts"), 0)
 }
 
+func TestStack_AddEvent(t *testing.T) {
+	s := make(Stack)
+	s.AddEvent("a", "go", "b", nil)
+	s.AddEvent("a", "go", "b", nil)
+	s.AddEvent("b", "go", "c")
+
+	dst, actions := s.GetEvent("a", "go")
+	assert.Equal(t, "b", dst)
+	assert.Len(t, actions, 2)
+
+	dst, actions = s.GetEvent("b", "go")
+	assert.Equal(t, "c", dst)
+	assert.NotNil(t, actions)
+	assert.Len(t, actions, 0)
+
+	dst, actions = s.GetEvent("c", "go")
+	assert.Equal(t, UnknownState, dst)
+	assert.Nil(t, actions)
+
+	assert.Empty(t, s.Get("a", "b"))
+
+	assert.Panics(t, func() {
+		s.AddEvent("a", "go", "c")
+	})
+}
+
+func TestStack_AddGuard(t *testing.T) {
+	guard := func(ctx context.Context) error { return nil }
+	s := make(Stack).
+		Add("a", "b", nil).
+		AddGuard("a", "b", guard).
+		Add("a", "b", nil).
+		AddGuard("a", "b", guard)
+
+	actions := s[StackKey{Src: "a", Dst: "b"}]
+	assert.Len(t, actions, 4)
+	assert.True(t, actions[0].Guard)
+	assert.True(t, actions[1].Guard)
+	assert.False(t, actions[2].Guard)
+	assert.False(t, actions[3].Guard)
+	assert.Len(t, s.Get("a", "b"), 4)
+}
+
+func TestStack_Wildcard(t *testing.T) {
+	exact := func(ctx context.Context) (context.Context, error) { return ctx, nil }
+	s := make(Stack).
+		Add("a", "b", exact).
+		Add("a", AnyState, nil, nil).
+		Add(AnyState, "cancelled", nil, nil, nil).
+		AddEvent(AnyState, "cancel", "cancelled").
+		AddEvent("a", "cancel", "b")
+
+	k, ok := s.Resolve("a", "b", "")
+	assert.True(t, ok)
+	assert.Equal(t, StackKey{Src: "a", Dst: "b"}, k)
+	assert.Len(t, s.Get("a", "b"), 1)
+
+	k, ok = s.Resolve("a", "cancelled", "")
+	assert.True(t, ok)
+	assert.Equal(t, StackKey{Src: "a", Dst: AnyState}, k) // source is more specific than destination
+	assert.Len(t, s.Get("a", "c"), 2)
+
+	k, ok = s.Resolve("c", "cancelled", "")
+	assert.True(t, ok)
+	assert.Equal(t, StackKey{Src: AnyState, Dst: "cancelled"}, k)
+	assert.Len(t, s.Get("c", "cancelled"), 3)
+
+	_, ok = s.Resolve("c", "d", "")
+	assert.False(t, ok)
+	assert.Nil(t, s.Get("c", "d"))
+
+	_, ok = s.Resolve("a", AnyState, "")
+	assert.False(t, ok, "wildcard is not the destination state")
+
+	dst, ok := s.Target("a", "cancel")
+	assert.True(t, ok)
+	assert.Equal(t, "b", dst)
+	dst, ok = s.Target("c", "cancel")
+	assert.True(t, ok)
+	assert.Equal(t, "cancelled", dst)
+
+	assert.Panics(t, func() {
+		s.AddEvent("c", "go", AnyState)
+	})
+}
+
 func TestAsync_Get(t *testing.T) {
 	r := make(Stack)
 	r.Add("a", "b", nil)
@@ -44,7 +131,7 @@ func TestAsync_Get(t *testing.T) {
 				defer wg.Done()
 				actions := r.Get("no exists", "not exists")
 				if len(actions) != 0 {
-					t.Fatal("not expected len actions")
+					t.Error("not expected len actions")
 				}
 			}()
 		}
@@ -58,7 +145,7 @@ func TestAsync_Get(t *testing.T) {
 				defer wg.Done()
 				actions := r.Get("no exists", "not exists")
 				if len(actions) != 0 {
-					t.Fatal("not expected len actions")
+					t.Error("not expected len actions")
 				}
 			}()
 		}
@@ -0,0 +1,109 @@
+package ffsm
+
+import (
+	"context"
+	"sync"
+)
+
+// StoreActionName is the name of the action in DispatchError if the state
+// was not saved to the Store.
+const StoreActionName = "store"
+
+// Record is the persisted state of FSM.
+type Record struct {
+	State   string
+	Version uint64 // incremented by each save
+}
+
+// Store persists state of FSM by ID of FSM.
+type Store interface {
+	// Load returns the persisted record of FSM or ErrStateNotFound.
+	Load(ctx context.Context, id string) (Record, error)
+
+	// Save persists new state of FSM after the transition from the record prev
+	// (zero record if the state was not persisted yet) and returns the new
+	// record. Save returns ConflictError if the version of persisted state is
+	// not equal to the version of prev. If Save fails the transition fails.
+	Save(ctx context.Context, id string, prev Record, state string) (Record, error)
+}
+
+// NewFSMWithStore returns new finite state machine with the state loaded from
+// the store (or with initial state if the store has not state of FSM). Each
+// successful transition of the main state is saved to the store before the
+// state is changed.
+//
+// NOTE: states of regions and the state set by SetState are not persisted.
+func NewFSMWithStore(ctx context.Context, wf Stack, initState string, store Store, id string) (*FSM, error) {
+	return loadFSM(ctx, wf, initState, store, id, NewFSM)
+}
+
+func loadFSM(ctx context.Context, wf Stack, initState string, store Store, id string, newFSM func(wf Stack, initState string) *FSM) (*FSM, error) {
+	state := initState
+	record, err := store.Load(ctx, id)
+	if err == nil {
+		state = record.State
+	} else if err != ErrStateNotFound {
+		return nil, err
+	}
+
+	e := newFSM(wf, state)
+	e.initState = wf.initial(initState)
+	e.store = store
+	e.id = id
+	e.record = record
+	return e, nil
+}
+
+// ID returns ID of FSM in the store.
+func (e *FSMOf[S, P]) ID() string {
+	return e.id
+}
+
+// save saves the state to the store.
+func (e *FSMOf[S, P]) save(ctx context.Context, state S) error {
+	if e.store == nil {
+		return nil
+	}
+	record, err := e.store.Save(ctx, e.id, e.record, StateName(state))
+	if err != nil {
+		return err
+	}
+	e.record = record
+	return nil
+}
+
+// NewMemoryStore returns the store which keeps states in memory.
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{records: map[string]Record{}}
+}
+
+// MemoryStore is the Store which keeps states in memory (thread-safe).
+type MemoryStore struct {
+	mu      sync.Mutex
+	records map[string]Record
+}
+
+// Load returns the record of FSM.
+func (s *MemoryStore) Load(ctx context.Context, id string) (Record, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	record, ok := s.records[id]
+	if !ok {
+		return Record{}, ErrStateNotFound
+	}
+	return record, nil
+}
+
+// Save saves new state of FSM.
+func (s *MemoryStore) Save(ctx context.Context, id string, prev Record, state string) (Record, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.records[id].Version != prev.Version {
+		return Record{}, ConflictError{ID: id, Version: prev.Version}
+	}
+	record := Record{State: state, Version: prev.Version + 1}
+	s.records[id] = record
+	return record, nil
+}
+
+var _ Store = (*MemoryStore)(nil)
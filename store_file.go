@@ -0,0 +1,105 @@
+package ffsm
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+// NewFileStore returns the store which keeps state of each FSM in the JSON
+// file in the directory.
+func NewFileStore(dir string) *FileStore {
+	return &FileStore{dir: dir}
+}
+
+// FileStore is the Store which keeps state of each FSM in the JSON file
+// in the directory (thread-safe). The file is replaced atomically.
+//
+// NOTE: the version is checked only among users of the same FileStore.
+type FileStore struct {
+	mu  sync.Mutex
+	dir string
+}
+
+type fileRecord struct {
+	State   string `json:"state"`
+	Version uint64 `json:"version"`
+}
+
+// Load returns the record of FSM.
+func (s *FileStore) Load(ctx context.Context, id string) (Record, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.load(id)
+}
+
+// Save saves new state of FSM.
+func (s *FileStore) Save(ctx context.Context, id string, prev Record, state string) (Record, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	current, err := s.load(id)
+	if err != nil && err != ErrStateNotFound {
+		return Record{}, err
+	}
+	if current.Version != prev.Version {
+		return Record{}, ConflictError{ID: id, Version: prev.Version}
+	}
+	record := Record{State: state, Version: prev.Version + 1}
+	return record, s.write(id, record)
+}
+
+func (s *FileStore) path(id string) string {
+	return filepath.Join(s.dir, url.PathEscape(id)+".json")
+}
+
+func (s *FileStore) load(id string) (Record, error) {
+	data, err := ioutil.ReadFile(s.path(id))
+	if os.IsNotExist(err) {
+		return Record{}, ErrStateNotFound
+	}
+	if err != nil {
+		return Record{}, err
+	}
+	var r fileRecord
+	if err := json.Unmarshal(data, &r); err != nil {
+		return Record{}, err
+	}
+	return Record{State: r.State, Version: r.Version}, nil
+}
+
+// write writes the record to temporary file and renames it to the file of FSM.
+func (s *FileStore) write(id string, record Record) error {
+	data, err := json.Marshal(fileRecord{State: record.State, Version: record.Version})
+	if err != nil {
+		return err
+	}
+	f, err := ioutil.TempFile(s.dir, ".ffsm-")
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(data); err != nil {
+		f.Close()
+		os.Remove(f.Name())
+		return err
+	}
+	if err := f.Sync(); err != nil {
+		f.Close()
+		os.Remove(f.Name())
+		return err
+	}
+	if err := f.Close(); err != nil {
+		os.Remove(f.Name())
+		return err
+	}
+	if err := os.Rename(f.Name(), s.path(id)); err != nil {
+		os.Remove(f.Name())
+		return err
+	}
+	return nil
+}
+
+var _ Store = (*FileStore)(nil)
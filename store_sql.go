@@ -0,0 +1,109 @@
+package ffsm
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"strconv"
+)
+
+// NewSQLStore returns the store which keeps states in the table of the
+// database. The table has columns id, state and version (see CreateTable).
+//
+// The state is saved only if its version in the table was not changed since
+// it was loaded (optimistic concurrency), otherwise Save returns ConflictError.
+func NewSQLStore(db *sql.DB, table string) *SQLStore {
+	return &SQLStore{
+		db:          db,
+		table:       table,
+		Placeholder: QuestionPlaceholder,
+	}
+}
+
+// SQLStore is the Store which keeps states in the table of the database.
+type SQLStore struct {
+	db    *sql.DB
+	table string
+
+	// Placeholder returns placeholder of the n-th (starts with 1) parameter
+	// of the query. QuestionPlaceholder by default (SQLite, MySQL), set
+	// DollarPlaceholder for PostgreSQL.
+	Placeholder func(n int) string
+}
+
+// QuestionPlaceholder returns "?" placeholder of the parameter.
+func QuestionPlaceholder(n int) string {
+	return "?"
+}
+
+// DollarPlaceholder returns "$n" placeholder of the parameter.
+func DollarPlaceholder(n int) string {
+	return "$" + strconv.Itoa(n)
+}
+
+// CreateTable creates the table of states if it is not exists.
+func (s *SQLStore) CreateTable(ctx context.Context) error {
+	_, err := s.db.ExecContext(ctx, fmt.Sprintf(`CREATE TABLE IF NOT EXISTS %s (
+	id VARCHAR(255) NOT NULL PRIMARY KEY,
+	state VARCHAR(255) NOT NULL,
+	version BIGINT NOT NULL
+)`, s.table))
+	return err
+}
+
+// Load returns the record of FSM.
+func (s *SQLStore) Load(ctx context.Context, id string) (Record, error) {
+	var record Record
+	err := s.db.QueryRowContext(ctx,
+		fmt.Sprintf("SELECT state, version FROM %s WHERE id = %s", s.table, s.Placeholder(1)),
+		id,
+	).Scan(&record.State, &record.Version)
+	if err == sql.ErrNoRows {
+		return Record{}, ErrStateNotFound
+	}
+	if err != nil {
+		return Record{}, err
+	}
+	return record, nil
+}
+
+// Save saves new state of FSM if the version of the state was not changed
+// since prev was loaded.
+func (s *SQLStore) Save(ctx context.Context, id string, prev Record, state string) (Record, error) {
+	record := Record{State: state, Version: prev.Version + 1}
+
+	if prev.Version == 0 {
+		_, err := s.db.ExecContext(ctx,
+			fmt.Sprintf("INSERT INTO %s (id, state, version) VALUES (%s, %s, %s)",
+				s.table, s.Placeholder(1), s.Placeholder(2), s.Placeholder(3)),
+			id, record.State, record.Version,
+		)
+		if err != nil {
+			// unique violation is the conflict with the concurrent insert
+			if _, loadErr := s.Load(ctx, id); loadErr == nil {
+				return Record{}, ConflictError{ID: id, Version: prev.Version}
+			}
+			return Record{}, err
+		}
+		return record, nil
+	}
+
+	res, err := s.db.ExecContext(ctx,
+		fmt.Sprintf("UPDATE %s SET state = %s, version = %s WHERE id = %s AND version = %s",
+			s.table, s.Placeholder(1), s.Placeholder(2), s.Placeholder(3), s.Placeholder(4)),
+		record.State, record.Version, id, prev.Version,
+	)
+	if err != nil {
+		return Record{}, err
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return Record{}, err
+	}
+	if n == 0 {
+		return Record{}, ConflictError{ID: id, Version: prev.Version}
+	}
+	return record, nil
+}
+
+var _ Store = (*SQLStore)(nil)
@@ -0,0 +1,32 @@
+package ffsm
+
+import (
+	"context"
+	"database/sql"
+	"testing"
+
+	_ "github.com/mattn/go-sqlite3"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSQLStore(t *testing.T) {
+	db, err := sql.Open("sqlite3", ":memory:")
+	require.NoError(t, err)
+	defer db.Close()
+	db.SetMaxOpenConns(1) // each connection has own in-memory database
+
+	store := NewSQLStore(db, "ffsm_states")
+	require.NoError(t, store.CreateTable(context.Background()))
+	require.NoError(t, store.CreateTable(context.Background()))
+	testStore(t, store)
+
+	store = NewSQLStore(db, "ffsm_states_conflict")
+	require.NoError(t, store.CreateTable(context.Background()))
+	testStoreConflict(t, store)
+}
+
+func TestSQLStore_Placeholder(t *testing.T) {
+	assert.Equal(t, "?", QuestionPlaceholder(2))
+	assert.Equal(t, "$2", DollarPlaceholder(2))
+}
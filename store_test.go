@@ -0,0 +1,130 @@
+package ffsm
+
+import (
+	"context"
+	"errors"
+	"io/ioutil"
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func testStore(t *testing.T, store Store) {
+	ctx := context.Background()
+	door := &door{}
+	wf := make(Stack).
+		Add(CloseDoor, OpenDoor, door.AccessOnlyBob).
+		Add(OpenDoor, CloseDoor)
+
+	_, err := store.Load(ctx, "door/1")
+	assert.Equal(t, ErrStateNotFound, err)
+
+	fsm, err := NewFSMWithStore(ctx, wf, CloseDoor, store, "door/1")
+	require.NoError(t, err)
+	assert.Equal(t, CloseDoor, fsm.State())
+	assert.Equal(t, "door/1", fsm.ID())
+
+	// failed transition is not saved
+	assert.Error(t, fsm.Dispatch(ctx, OpenDoor))
+	_, err = store.Load(ctx, "door/1")
+	assert.Equal(t, ErrStateNotFound, err)
+
+	bobCtx := context.WithValue(ctx, "__name", "bob")
+	require.NoError(t, fsm.Dispatch(bobCtx, OpenDoor))
+	record, err := store.Load(ctx, "door/1")
+	require.NoError(t, err)
+	assert.Equal(t, Record{State: OpenDoor, Version: 1}, record)
+	fsm.Stop()
+
+	// restart
+	fsm, err = NewFSMWithStore(ctx, wf, CloseDoor, store, "door/1")
+	require.NoError(t, err)
+	assert.Equal(t, OpenDoor, fsm.State())
+	require.NoError(t, fsm.Dispatch(ctx, CloseDoor))
+	record, err = store.Load(ctx, "door/1")
+	require.NoError(t, err)
+	assert.Equal(t, Record{State: CloseDoor, Version: 2}, record)
+
+	_, err = store.Load(ctx, "door/2")
+	assert.Equal(t, ErrStateNotFound, err)
+}
+
+func testStoreConflict(t *testing.T, store Store) {
+	ctx := context.Background()
+	wf := make(Stack).
+		Add(CloseDoor, OpenDoor).
+		Add(OpenDoor, CloseDoor)
+
+	replica1, err := NewFSMWithStore(ctx, wf, CloseDoor, store, "door")
+	require.NoError(t, err)
+	replica2, err := NewFSMWithStore(ctx, wf, CloseDoor, store, "door")
+	require.NoError(t, err)
+
+	// both replicas insert the state
+	require.NoError(t, replica1.Dispatch(ctx, OpenDoor))
+	err = replica2.Dispatch(ctx, OpenDoor)
+	assert.True(t, errors.Is(err, ErrStateConflict))
+	var conflictErr ConflictError
+	require.True(t, errors.As(err, &conflictErr))
+	assert.Equal(t, ConflictError{ID: "door", Version: 0}, conflictErr)
+	assert.Equal(t, CloseDoor, replica2.State())
+
+	replica2, err = NewFSMWithStore(ctx, wf, CloseDoor, store, "door")
+	require.NoError(t, err)
+	assert.Equal(t, OpenDoor, replica2.State())
+
+	// both replicas update the state
+	require.NoError(t, replica1.Dispatch(ctx, CloseDoor))
+	err = replica2.Dispatch(ctx, CloseDoor)
+	require.True(t, errors.As(err, &conflictErr))
+	assert.Equal(t, ConflictError{ID: "door", Version: 1}, conflictErr)
+
+	record, err := store.Load(ctx, "door")
+	require.NoError(t, err)
+	assert.Equal(t, Record{State: CloseDoor, Version: 2}, record)
+}
+
+func TestMemoryStore(t *testing.T) {
+	testStore(t, NewMemoryStore())
+	testStoreConflict(t, NewMemoryStore())
+}
+
+func TestFileStore(t *testing.T) {
+	dir, err := ioutil.TempDir("", "ffsm")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	testStore(t, NewFileStore(dir))
+	testStoreConflict(t, NewFileStore(dir))
+}
+
+type failedStore struct {
+	Store
+}
+
+func (s failedStore) Save(ctx context.Context, id string, prev Record, state string) (Record, error) {
+	return Record{}, errors.New("store is unavailable")
+}
+
+func Test_FSM_StoreFailed(t *testing.T) {
+	var released bool
+	wf := make(Stack).AddAction(CloseDoor, OpenDoor, Action{
+		Procedure: (door{}).Empty,
+		Compensate: func(ctx context.Context) (context.Context, error) {
+			released = true
+			return ctx, nil
+		},
+	})
+	fsm, err := NewFSMWithStore(context.Background(), wf, CloseDoor, failedStore{NewMemoryStore()}, "1")
+	require.NoError(t, err)
+
+	err = fsm.Dispatch(context.Background(), OpenDoor)
+	var dispatchErr DispatchError
+	require.True(t, errors.As(err, &dispatchErr))
+	assert.Equal(t, StoreActionName, dispatchErr.ActionName)
+	assert.EqualError(t, err, `store is unavailable ("close"=>"open")`)
+	assert.True(t, released)
+	assert.Equal(t, CloseDoor, fsm.State())
+}
@@ -0,0 +1,226 @@
+package ffsm
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// ValidationReport is the result of Stack.Validate. Lists are sorted.
+type ValidationReport struct {
+	// Unreachable states can not be reached from the initial state.
+	Unreachable []string
+	// DeadEnds are reachable states that are not final and have no
+	// transitions from them.
+	DeadEnds []string
+	// Undeclared states are used by Stack (or are initial) but are not declared.
+	Undeclared []string
+	// MixedNil are transitions registered with nil procedures mixed with
+	// handlers (usually the transition is registered twice by mistake).
+	MixedNil []StackKey
+}
+
+// OK reports whether the report has no problems.
+func (r ValidationReport) OK() bool {
+	return len(r.Unreachable) == 0 && len(r.DeadEnds) == 0 && len(r.Undeclared) == 0 && len(r.MixedNil) == 0
+}
+
+// Err returns nil if the report has no problems, otherwise error of the
+// report that matches ErrInvalidStack by errors.Is.
+func (r ValidationReport) Err() error {
+	if r.OK() {
+		return nil
+	}
+	return fmt.Errorf("%w: %s", ErrInvalidStack, r.String())
+}
+
+func (r ValidationReport) String() string {
+	if r.OK() {
+		return "ok"
+	}
+	var problems []string
+	if len(r.Unreachable) > 0 {
+		problems = append(problems, fmt.Sprintf("unreachable states %q", r.Unreachable))
+	}
+	if len(r.DeadEnds) > 0 {
+		problems = append(problems, fmt.Sprintf("dead-end states %q", r.DeadEnds))
+	}
+	if len(r.Undeclared) > 0 {
+		problems = append(problems, fmt.Sprintf("undeclared states %q", r.Undeclared))
+	}
+	for _, k := range r.MixedNil {
+		s := fmt.Sprintf("nil procedure mixed with handlers %q=>%q", k.Src, k.Dst)
+		if k.Event != "" {
+			s += fmt.Sprintf(" by %q", k.Event)
+		}
+		problems = append(problems, s)
+	}
+	return strings.Join(problems, "; ")
+}
+
+// Validate checks the stack for the initial state, declared states and
+// final states. Parent states of declared states are declared too. If states
+// is empty all states used by the stack are treated as declared.
+//
+// Wildcards, events, hierarchy of states and initial child states are
+// resolved as by dispatching. Hooks of the states are not transitions.
+//
+// The report has names of states (see StateName).
+func (r StackOf[S, P]) Validate(initState S, states []S, finals []S) ValidationReport {
+	if r == nil {
+		panic("Stack.Validate: stack is empty")
+	}
+	return validate(r.names(), StateName(initState), stateNames(states), stateNames(finals))
+}
+
+func validate(r Stack, initState string, states []string, finals []string) ValidationReport {
+	var report ValidationReport
+
+	used := map[string]bool{}
+	if initState != UnknownState {
+		used[initState] = true
+	}
+	for k, actions := range r {
+		for _, s := range []string{k.Src, k.Dst} {
+			if s != UnknownState && s != AnyState {
+				used[s] = true
+			}
+		}
+		if k.Kind == TransitionKind && mixedNil(actions) {
+			report.MixedNil = append(report.MixedNil, k)
+		}
+	}
+
+	declared := map[string]bool{}
+	for _, s := range states {
+		for _, p := range StatePath(s) {
+			declared[p] = true
+		}
+	}
+	if len(states) == 0 {
+		for s := range used {
+			declared[s] = true
+		}
+	}
+	for s := range used {
+		if !declared[s] {
+			report.Undeclared = append(report.Undeclared, s)
+		}
+	}
+
+	all := map[string]bool{}
+	for s := range used {
+		all[s] = true
+	}
+	for s := range declared {
+		all[s] = true
+	}
+
+	// occupied states are states that can be current state of FSM
+	reached := map[string]bool{}
+	occupied := map[string]bool{}
+	var queue []string
+	visit := func(target string) {
+		s := r.initial(target)
+		if occupied[s] {
+			return
+		}
+		occupied[s] = true
+		for _, p := range StatePath(s) {
+			reached[p] = true
+		}
+		queue = append(queue, s)
+	}
+	if initState != UnknownState {
+		visit(initState)
+	}
+	for len(queue) > 0 {
+		s := queue[0]
+		queue = queue[1:]
+		targets := stackTargets(r, s, all)
+		if len(targets) == 0 && !isFinal(s, finals) {
+			report.DeadEnds = append(report.DeadEnds, s)
+		}
+		for _, t := range targets {
+			visit(t)
+		}
+	}
+	for s := range all {
+		if !reached[s] {
+			report.Unreachable = append(report.Unreachable, s)
+		}
+	}
+
+	sort.Strings(report.Unreachable)
+	sort.Strings(report.DeadEnds)
+	sort.Strings(report.Undeclared)
+	sort.Slice(report.MixedNil, func(i, j int) bool {
+		a, b := report.MixedNil[i], report.MixedNil[j]
+		if a.Src != b.Src {
+			return a.Src < b.Src
+		}
+		if a.Dst != b.Dst {
+			return a.Dst < b.Dst
+		}
+		return a.Event < b.Event
+	})
+	return report
+}
+
+// stackTargets returns destination states of transitions from src. The
+// wildcard destination is any of states.
+func stackTargets(r Stack, src string, states map[string]bool) []string {
+	path := StatePath(src)
+	from := map[string]bool{AnyState: true}
+	for _, p := range path {
+		from[p] = true
+	}
+
+	var targets []string
+	events := map[string]bool{}
+	for k := range r {
+		if k.Kind != TransitionKind || !from[k.Src] {
+			continue
+		}
+		if k.Event != "" {
+			if !events[k.Event] {
+				events[k.Event] = true
+				if key, ok := r.Resolve(src, UnknownState, k.Event); ok {
+					targets = append(targets, key.Dst)
+				}
+			}
+			continue
+		}
+		if k.Dst != AnyState {
+			targets = append(targets, k.Dst)
+			continue
+		}
+		for s := range states {
+			targets = append(targets, s)
+		}
+	}
+	sort.Strings(targets)
+	return targets
+}
+
+func isFinal(state string, finals []string) bool {
+	for _, f := range finals {
+		if state == f || isDescendant(state, f) {
+			return true
+		}
+	}
+	return false
+}
+
+// mixedNil reports whether actions have nil procedures and handlers.
+func mixedNil(actions []Action) bool {
+	var hasNil, hasHandler bool
+	for _, a := range actions {
+		if a.empty() {
+			hasNil = true
+		} else {
+			hasHandler = true
+		}
+	}
+	return hasNil && hasHandler
+}
@@ -0,0 +1,69 @@
+package ffsm
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_Stack_Validate_OK(t *testing.T) {
+	wf := make(Stack).
+		Add(CloseDoor, OpenDoor).
+		Add(OpenDoor, CloseDoor)
+
+	report := wf.Validate(CloseDoor, []string{CloseDoor, OpenDoor}, nil)
+	assert.True(t, report.OK())
+	assert.NoError(t, report.Err())
+	assert.Equal(t, "ok", report.String())
+
+	// without declared states
+	assert.True(t, wf.Validate(CloseDoor, nil, nil).OK())
+}
+
+func Test_Stack_Validate_Problems(t *testing.T) {
+	handler := func(ctx context.Context) (context.Context, error) { return ctx, nil }
+	wf := make(Stack).
+		Add("new", "paid", nil).
+		Add("new", "paid", handler).
+		Add("paid", "shiped"). // typo
+		Add("shipped", "done").
+		Add("new", "canceled")
+
+	report := wf.Validate("new", []string{"new", "paid", "shipped", "done", "canceled"}, []string{"done", "canceled"})
+	assert.False(t, report.OK())
+	assert.Equal(t, []string{"done", "shipped"}, report.Unreachable)
+	assert.Equal(t, []string{"shiped"}, report.DeadEnds)
+	assert.Equal(t, []string{"shiped"}, report.Undeclared)
+	assert.Equal(t, []StackKey{{Src: "new", Dst: "paid"}}, report.MixedNil)
+
+	err := report.Err()
+	assert.True(t, errors.Is(err, ErrInvalidStack))
+	assert.Contains(t, err.Error(), `unreachable states ["done" "shipped"]`)
+	assert.Contains(t, err.Error(), `nil procedure mixed with handlers "new"=>"paid"`)
+}
+
+func Test_Stack_Validate_Resolving(t *testing.T) {
+	wf := make(Stack).
+		SetInitial("active", "active.picking").
+		AddEvent("idle", "start", "active").
+		AddEvent("active", "pack", "active.packing").
+		AddEvent("active.packing", "pack", "active.packing"). // child overrides event of parent
+		Add("active", "idle").
+		Add(AnyState, "failed").
+		OnEnter("archived")
+
+	report := wf.Validate("idle", nil, []string{"failed"})
+	assert.Empty(t, report.DeadEnds)
+	assert.Empty(t, report.Undeclared)
+	assert.Equal(t, []string{"archived"}, report.Unreachable)
+
+	// wildcard destination
+	wf = make(Stack).
+		Add("a", AnyState).
+		Add("b", "a")
+	report = wf.Validate("a", []string{"a", "b", "c"}, nil)
+	assert.Empty(t, report.Unreachable)
+	assert.Equal(t, []string{"c"}, report.DeadEnds)
+}